@@ -0,0 +1,54 @@
+package fishingboat
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+type AccessLogConfig struct {
+	// file to append to, stdout if empty
+	Path   string `json:"path,omitempty"`
+	Format string `json:"format,omitempty"`
+}
+
+// access is what gets logged about one proxied connection.
+type access struct {
+	began    time.Time
+	backend  string
+	bytesIn  int64
+	bytesOut int64
+	cold     bool
+	cause    string
+}
+
+func SetupAccessLog(config *AccessLogConfig) (logger *slog.Logger, err error) {
+	out := os.Stdout
+	if config.Path != "" {
+		out, err = os.OpenFile(config.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return
+		}
+	}
+	if strings.ToLower(config.Format) == "json" {
+		return slog.New(slog.NewJSONHandler(out, nil)), nil
+	}
+	return slog.New(slog.NewTextHandler(out, nil)), nil
+}
+
+func (s *Server) LogAccess(app Service, remote string, a access) {
+	if s.AccessLog == nil {
+		return
+	}
+	s.AccessLog.Info("connection",
+		"service", app.Name,
+		"client", remote,
+		"backend", a.backend,
+		"bytesIn", a.bytesIn,
+		"bytesOut", a.bytesOut,
+		"duration", time.Since(a.began),
+		"cold", a.cold,
+		"error", a.cause,
+	)
+}
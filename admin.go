@@ -0,0 +1,487 @@
+package fishingboat
+
+import (
+	"context"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type AdminConfig struct {
+	Bind string `json:"bind"`
+	// required as a bearer token when set, and grants control. Without any
+	// token or client CA, only a loopback bind gets control.
+	Token string `json:"token,omitempty"`
+	// more tokens, each read-only or with control
+	Tokens []AdminToken `json:"tokens,omitempty"`
+	// serve over HTTPS, with client certificates as another way in
+	TLS *AdminTLS `json:"tls,omitempty"`
+	// serve the web dashboard at /
+	Dashboard bool `json:"dashboard,omitempty"`
+	// serve net/http/pprof under /debug/pprof/
+	Pprof bool `json:"pprof,omitempty"`
+}
+
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+const (
+	StateStopped  = "stopped"
+	StateStarting = "starting"
+	StateRunning  = "running"
+	StateIdle     = "idle"
+	StateCooldown = "cooldown"
+)
+
+type ServiceStatus struct {
+	Name        string      `json:"name"`
+	State       string      `json:"state"`
+	Connections uint        `json:"connections"`
+	Ports       map[int]int `json:"ports,omitempty"`
+	StartedAt   *time.Time  `json:"startedAt,omitempty"`
+	// Docker host it is placed on, with nodes configured
+	Node string `json:"node,omitempty"`
+	// seconds until the service is scaled down
+	Cooldown     *int       `json:"cooldown,omitempty"`
+	Requested    *Resources `json:"requested,omitempty"`
+	Usage        *Resources `json:"usage,omitempty"`
+	BreakerOpen  bool       `json:"breakerOpen,omitempty"`
+	FailedStarts int        `json:"failedStarts,omitempty"`
+	ColdStartsMs []int64    `json:"coldStartsMs,omitempty"`
+	// its listeners are closed until it is enabled again
+	Disabled bool `json:"disabled,omitempty"`
+	// recent cold starts with their phases, and all of them bucketed in seconds
+	ColdStarts         []ColdStart `json:"coldStarts,omitempty"`
+	ColdStartHistogram *Histogram  `json:"coldStartHistogram,omitempty"`
+}
+
+func (s *Server) ServiceStatus(app Service) ServiceStatus {
+	status := ServiceStatus{Name: app.Name, State: StateStopped, Requested: app.ResourceRequest}
+	status.Connections = s.ConnCount(app.Name)
+	func() {
+		s.ServerLock.RLock()
+		defer s.ServerLock.RUnlock()
+		if ports, ok := s.ServiceProxyHostPortMap[app.Name]; ok {
+			status.Ports = make(map[int]int)
+			for containerPort, hostPort := range ports {
+				status.Ports[containerPort] = hostPort
+			}
+		}
+		if started, ok := s.ServiceStartTime[app.Name]; ok {
+			status.StartedAt = &started
+			status.State = StateIdle
+			if status.Connections > 0 {
+				status.State = StateRunning
+			}
+		}
+		if _, ok := s.ServiceStartups[app.Name]; ok {
+			status.State = StateStarting
+		}
+		for _, cs := range s.ServiceColdStarts[app.Name] {
+			status.ColdStartsMs = append(status.ColdStartsMs, cs.TotalMs)
+		}
+		status.ColdStarts = append(status.ColdStarts, s.ServiceColdStarts[app.Name]...)
+		if h, ok := s.ServiceColdStartHistograms[app.Name]; ok {
+			histogram := *h
+			histogram.Counts = append([]uint64(nil), h.Counts...)
+			status.ColdStartHistogram = &histogram
+		}
+	}()
+	status.Disabled = s.Disabled(app.Name)
+	if remaining, ok := s.RemainingCooldown(app.Name); ok && status.State == StateIdle {
+		status.State = StateCooldown
+		seconds := int(remaining.Seconds())
+		status.Cooldown = &seconds
+	}
+	func() {
+		s.TrackedResourcesLock.RLock()
+		defer s.TrackedResourcesLock.RUnlock()
+		if usage, ok := s.MeasuredResources[app.Name]; ok {
+			status.Usage = &usage
+		}
+		status.Node = s.ServiceNodes[app.Name]
+	}()
+	status.BreakerOpen, status.FailedStarts, _ = s.BreakerState(app.Name)
+	return status
+}
+
+// StartService wakes app without a connection, scheduling the usual
+// cooldown if nothing connects.
+func (s *Server) StartService(app Service) (err error) {
+	err = s.LaunchGroup(s.ctx, app)
+	if err != nil {
+		return
+	}
+	s.ScheduleGroupKill(app)
+	return
+}
+
+// HoldService starts app if needed and keeps it up for at least d,
+// regardless of connections.
+func (s *Server) HoldService(app Service, d time.Duration) (err error) {
+	err = s.StartService(app)
+	if err != nil {
+		return
+	}
+	s.KeepWarm(app, s.Clock.Now().Add(d))
+	return
+}
+
+// HoldAuthorized lets a service's holdToken stand in for the admin token,
+// but only to hold that service.
+func (s *Server) HoldAuthorized(path string, given string) bool {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 3 || parts[0] != "services" || parts[2] != "hold" {
+		return false
+	}
+	app := s.FindService(parts[1])
+	if app == nil || app.HoldToken == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(given), []byte(app.HoldToken)) == 1
+}
+
+// StopService stops app straight away, cutting off any connections.
+func (s *Server) StopService(app Service) (err error) {
+	unlock, err := s.Cluster.Lock(s.ctx, app.Name)
+	if err != nil {
+		return
+	}
+	err = s.StopContainerWithAction(app.Name, app.IdleAction, true)
+	unlock()
+	if err != nil {
+		return
+	}
+	s.ClearKillTime(app.Name)
+	s.ServerLock.Lock()
+	defer s.ServerLock.Unlock()
+	delete(s.ServiceWarmUntil, app.Name)
+	return
+}
+
+// SleepService scales app down now, first giving its connections until
+// drain (or its drainTimeout if negative) to finish.
+func (s *Server) SleepService(app Service, drain time.Duration) (err error) {
+	if drain < 0 {
+		drain = time.Duration(app.DrainTimeout) * time.Second
+	}
+	s.waitDrained(app, drain)
+	err = s.StopService(app)
+	if errors.Is(err, ErrNoContainer) {
+		err = nil
+	}
+	return
+}
+
+// waitDrained waits up to timeout for app's proxied connections to close.
+func (s *Server) waitDrained(app Service, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if s.ConnCount(app.Name) == 0 {
+			return
+		}
+		time.Sleep(1 * time.Second)
+	}
+}
+
+// ResetService removes app's container and forgets everything learned about
+// it, so the next wake starts from scratch.
+func (s *Server) ResetService(app Service) (err error) {
+	unlock, err := s.Cluster.Lock(s.ctx, app.Name)
+	if err != nil {
+		return
+	}
+	err = s.StopContainerWithAction(app.Name, IdleRemove, true)
+	unlock()
+	if err != nil && !errors.Is(err, ErrNoContainer) {
+		return
+	}
+	err = nil
+	s.ClearKillTime(app.Name)
+	s.ServerLock.Lock()
+	defer s.ServerLock.Unlock()
+	delete(s.ServiceWarmUntil, app.Name)
+	delete(s.ServiceBreakers, app.Name)
+	delete(s.ServiceRecreatePending, app.Name)
+	delete(s.ServiceAffinity, app.Name)
+	delete(s.ServiceAutoscalers, app.Name)
+	delete(s.ServiceNextReplica, app.Name)
+	return
+}
+
+// ServeAdmin runs the admin API, along with the dashboard if enabled:
+//
+//	GET  /
+//	GET  /healthz
+//	GET  /readyz
+//	GET  /audit?service=&action=&since=
+//	GET  /debug/pprof/
+//	GET  /services
+//	GET  /services/{name}
+//	GET  /services/{name}/logs?tail=N&follow=true
+//	POST /services/{name}/start
+//	POST /services/{name}/stop
+//	POST /services/{name}/sleep?drain=seconds
+//	POST /services/{name}/reset
+//	POST /services/{name}/disable
+//	POST /services/{name}/enable
+//	POST /services/{name}/hold?minutes=N (also accepts the service's holdToken)
+func (s *Server) ServeAdmin(ctx context.Context) {
+	s.Logger.Info("Serving admin API", "bind", s.Config.Admin.Bind)
+	server := &http.Server{Addr: s.Config.Admin.Bind, Handler: http.HandlerFunc(s.HandleAdmin)}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	var err error
+	if tlsConfig := s.Config.Admin.TLS; tlsConfig != nil {
+		server.TLSConfig, err = tlsConfig.Config()
+		if err == nil {
+			err = server.ListenAndServeTLS(tlsConfig.Cert, tlsConfig.Key)
+		}
+	} else {
+		err = server.ListenAndServe()
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		s.Logger.Error("Error serving admin API", "err", err)
+	}
+}
+
+func (s *Server) HandleAdmin(w http.ResponseWriter, r *http.Request) {
+	// left open for supervisors and monitoring
+	switch r.URL.Path {
+	case "/healthz":
+		s.HandleHealth(w, false)
+		return
+	case "/readyz":
+		s.HandleHealth(w, true)
+		return
+	}
+	if r.URL.Path == "/" && s.Config.Admin.Dashboard {
+		// the page itself is static, its API calls are authorized
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(dashboardHTML)
+		return
+	}
+	role := s.AdminRole(r)
+	if role == "" && s.HoldAuthorized(r.URL.Path, bearerToken(r)) {
+		role = RoleControl
+	}
+	if role == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if needsControl(r) && role != RoleControl {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	if r.URL.Path == "/audit" {
+		s.HandleAudit(w, r)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/debug/pprof/") && s.Config.Admin.Pprof {
+		HandlePprof(w, r)
+		return
+	}
+
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if path[0] != "services" || len(path) > 3 {
+		http.NotFound(w, r)
+		return
+	}
+	if len(path) == 1 {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		statuses := make([]ServiceStatus, 0)
+		for _, app := range s.Instances() {
+			statuses = append(statuses, s.ServiceStatus(app))
+		}
+		s.writeJSON(w, statuses)
+		return
+	}
+
+	app := s.FindService(path[1])
+	if app == nil {
+		http.Error(w, "unknown service", http.StatusNotFound)
+		return
+	}
+	if len(path) == 2 {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.writeJSON(w, s.ServiceStatus(*app))
+		return
+	}
+
+	if path[2] == "logs" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.HandleLogs(w, r, *app)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var err error
+	switch path[2] {
+	case "start":
+		err = s.StartService(*app)
+	case "stop":
+		err = s.StopService(*app)
+	case "sleep":
+		drain := time.Duration(-1)
+		if r.URL.Query().Has("drain") {
+			seconds, parseErr := strconv.Atoi(r.URL.Query().Get("drain"))
+			if parseErr != nil || seconds < 0 {
+				http.Error(w, "drain must be a number of seconds", http.StatusBadRequest)
+				return
+			}
+			drain = time.Duration(seconds) * time.Second
+		}
+		err = s.SleepService(*app, drain)
+	case "reset":
+		err = s.ResetService(*app)
+	case "disable":
+		err = s.DisableService(*app)
+	case "enable":
+		err = s.EnableService(*app)
+	case "hold":
+		minutes, parseErr := strconv.Atoi(r.URL.Query().Get("minutes"))
+		if parseErr != nil || minutes <= 0 {
+			http.Error(w, "minutes must be a positive number", http.StatusBadRequest)
+			return
+		}
+		err = s.HoldService(*app, time.Duration(minutes)*time.Minute)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err == nil {
+		action := AuditStop
+		switch path[2] {
+		case "start", "hold":
+			action = AuditWake
+		case "disable":
+			action = AuditDisable
+		case "enable":
+			action = AuditEnable
+		}
+		s.AuditReason(action, *app, "admin "+path[2], r.RemoteAddr, nil)
+	}
+	if err != nil {
+		if errors.Is(err, ErrNoListeners) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.Logger.Error("Error handling admin "+path[2], "service", app.Name, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.Logger.Info("Admin "+path[2], "service", app.Name, "remote", r.RemoteAddr)
+	s.writeJSON(w, s.ServiceStatus(*app))
+}
+
+func (s *Server) HandleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	var since time.Time
+	if query.Get("since") != "" {
+		var err error
+		since, err = time.Parse(time.RFC3339, query.Get("since"))
+		if err != nil {
+			http.Error(w, "since must be an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+	}
+	s.writeJSON(w, s.AuditLog.Query(query.Get("service"), query.Get("action"), since))
+}
+
+type ProxyHealth struct {
+	Docker            string `json:"docker"`
+	Listeners         int    `json:"listeners"`
+	ExpectedListeners int    `json:"expectedListeners"`
+	Services          int    `json:"services"`
+	// proxied connections out of maxConnections, when it is set
+	Connections    int `json:"connections,omitempty"`
+	MaxConnections int `json:"maxConnections,omitempty"`
+}
+
+// HandleHealth reports the proxy's own health. Liveness only needs the
+// Docker daemon to answer; readiness also needs every listener accepting.
+func (s *Server) HandleHealth(w http.ResponseWriter, ready bool) {
+	health := ProxyHealth{
+		Docker:            "ok",
+		Listeners:         int(s.Listeners.Load()),
+		ExpectedListeners: int(s.ExpectedListeners.Load()),
+		Services:          len(s.Config.Services),
+		Connections:       len(s.ConnSlots),
+		MaxConnections:    cap(s.ConnSlots),
+	}
+	healthy := true
+	if err := s.PingDocker(); err != nil {
+		health.Docker = err.Error()
+		healthy = false
+	}
+	if ready && health.Listeners < health.ExpectedListeners {
+		healthy = false
+	}
+	if !healthy {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(health)
+		return
+	}
+	s.writeJSON(w, health)
+}
+
+func (s *Server) PingDocker() error {
+	cli, err := s.DockerHost("")
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = cli.Ping(ctx)
+	return err
+}
+
+func HandlePprof(w http.ResponseWriter, r *http.Request) {
+	switch strings.TrimPrefix(r.URL.Path, "/debug/pprof/") {
+	case "cmdline":
+		pprof.Cmdline(w, r)
+	case "profile":
+		pprof.Profile(w, r)
+	case "symbol":
+		pprof.Symbol(w, r)
+	case "trace":
+		pprof.Trace(w, r)
+	default:
+		// the index and named profiles like goroutine and heap
+		pprof.Index(w, r)
+	}
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		s.Logger.Error("Error writing admin response", "err", err)
+	}
+}
@@ -0,0 +1,116 @@
+package fishingboat
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	RoleRead    = "read"
+	RoleControl = "control"
+)
+
+// AdminToken grants a role, read to view status and the audit log, or
+// control to also start, stop, and profile services.
+type AdminToken struct {
+	Token string `json:"token"`
+	Role  string `json:"role"`
+}
+
+// AdminTLS serves the admin API over HTTPS, optionally checking client
+// certificates against ClientCA.
+type AdminTLS struct {
+	Cert     string `json:"cert"`
+	Key      string `json:"key"`
+	ClientCA string `json:"clientCA,omitempty"`
+	// role by client certificate common name, others signed by clientCA can read
+	ClientRoles map[string]string `json:"clientRoles,omitempty"`
+}
+
+func (t *AdminTLS) Config() (config *tls.Config, err error) {
+	config = &tls.Config{}
+	if t.ClientCA != "" {
+		var pem []byte
+		pem, err = os.ReadFile(t.ClientCA)
+		if err != nil {
+			return
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", t.ClientCA)
+		}
+		// health checks and token holders don't need a certificate
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return
+}
+
+// bearerToken is the token in the Authorization header. Query parameters
+// aren't accepted, they end up in access logs and browser history.
+func bearerToken(r *http.Request) string {
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// loopbackBind reports whether the admin API only listens on loopback.
+func loopbackBind(bind string) bool {
+	host, _, err := net.SplitHostPort(bind)
+	if err != nil {
+		return false
+	}
+	if strings.ToLower(host) == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func adminRole(role string) string {
+	if strings.ToLower(role) == RoleControl {
+		return RoleControl
+	}
+	return RoleRead
+}
+
+// AdminRole works out what a request may do from its token or client
+// certificate, or "" if it isn't authenticated. With no authentication
+// configured at all, everyone has control if the API is bound to loopback
+// and can only read otherwise.
+func (s *Server) AdminRole(r *http.Request) string {
+	config := s.Config.Admin
+	if given := bearerToken(r); given != "" {
+		if config.Token != "" && subtle.ConstantTimeCompare([]byte(given), []byte(config.Token)) == 1 {
+			return RoleControl
+		}
+		for _, token := range config.Tokens {
+			if token.Token != "" && subtle.ConstantTimeCompare([]byte(given), []byte(token.Token)) == 1 {
+				return adminRole(token.Role)
+			}
+		}
+	}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && config.TLS != nil {
+		name := r.TLS.VerifiedChains[0][0].Subject.CommonName
+		return adminRole(config.TLS.ClientRoles[name])
+	}
+	if config.Token == "" && len(config.Tokens) == 0 && (config.TLS == nil || config.TLS.ClientCA == "") {
+		if loopbackBind(config.Bind) {
+			return RoleControl
+		}
+		return RoleRead
+	}
+	return ""
+}
+
+// needsControl reports whether a request changes anything or exposes
+// profiling, as opposed to just reading status.
+func needsControl(r *http.Request) bool {
+	if strings.HasPrefix(r.URL.Path, "/debug/pprof/") {
+		return true
+	}
+	return r.Method != http.MethodGet && r.Method != http.MethodHead
+}
@@ -0,0 +1,98 @@
+package fishingboat
+
+import (
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// AdoptContainers rebuilds the resource ledger and port mappings from managed
+// containers that kept running while fishingboat was down, and gives them a
+// cooldown so they still scale down if nobody connects. Timers saved before
+// the restart are picked back up. Warm pool containers go back in the pool
+// rather than being treated as services someone woke.
+func (s *Server) AdoptContainers(saved map[string]ServiceState) (err error) {
+	for _, app := range s.Instances() {
+		err = s.adoptContainer(app, saved)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (s *Server) adoptContainer(app Service, saved map[string]ServiceState) (err error) {
+	err = s.LocateService(app)
+	if err != nil {
+		return
+	}
+	cli, err := s.Docker(app.Name)
+	if err != nil {
+		return
+	}
+
+	var cont *types.Container
+	cont, err = s.FindContainer(cli, app.Name)
+	if err != nil {
+		return
+	}
+	if cont == nil || (cont.State != "running" && cont.State != "paused") {
+		return
+	}
+
+	ctx, cancel := s.dockerContext(s.ctx)
+	defer cancel()
+	var inspect types.ContainerJSON
+	inspect, err = cli.ContainerInspect(ctx, cont.ID)
+	if err != nil {
+		return
+	}
+	startTime, parseErr := time.Parse(time.RFC3339Nano, inspect.State.StartedAt)
+	if parseErr != nil {
+		startTime = s.Clock.Now()
+	}
+
+	func() {
+		// it's already running, so count it even if that overcommits. A
+		// paused pool container keeps its reservation for whoever takes it,
+		// same as when it was warmed.
+		s.TrackedResourcesLock.Lock()
+		defer s.TrackedResourcesLock.Unlock()
+		s.allocate(app, 1)
+	}()
+	err = s.LoadPortMappings(cli, app, cont.ID)
+	if err != nil {
+		return
+	}
+	err = s.TrackAllocations(cli, app, cont.ID)
+	if err != nil {
+		return
+	}
+	if s.findPoolMember(app.Name) != nil {
+		s.Logger.Info("Adopted pooled container", "service", app.Name, "container", cont.ID, "state", cont.State)
+		if cont.State == "running" {
+			// it was still warming, finish putting it in the pool
+			return s.StopContainerWithAction(app.Name, poolAction(app), false)
+		}
+		return
+	}
+	if state, ok := saved[app.Name]; ok {
+		s.RestoreState(app.Name, state)
+	}
+	func() {
+		s.ServerLock.Lock()
+		defer s.ServerLock.Unlock()
+		s.ServiceStartTime[app.Name] = startTime
+	}()
+	if s.LastUsed(app.Name).IsZero() {
+		s.Touch(app.Name)
+	}
+	if _, ok := s.KillTime(app.Name); !ok && s.ConnCount(app.Name) == 0 {
+		s.SetKillTime(app.Name, s.Clock.Now().Add(time.Duration(app.CoolDown)*time.Second))
+	}
+	if cont.State == "running" {
+		s.AcquireDependencies(app)
+	}
+	s.Logger.Info("Adopted container", "service", app.Name, "container", cont.ID, "state", cont.State)
+	return
+}
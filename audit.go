@@ -0,0 +1,121 @@
+package fishingboat
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	AuditWake         = "wake"
+	AuditStop         = "stop"
+	AuditEvict        = "evict"
+	AuditReject       = "reject"
+	AuditLaunchFailed = "launchFailed"
+	AuditRemediate    = "remediate"
+	AuditBan          = "ban"
+	AuditDisable      = "disable"
+	AuditEnable       = "enable"
+)
+
+type AuditConfig struct {
+	// also append entries to this file as JSON lines
+	Path string `json:"path,omitempty"`
+	// entries kept in memory for the admin API, defaults to 1000
+	Size int `json:"size,omitempty"`
+}
+
+type AuditEntry struct {
+	Time    time.Time `json:"time"`
+	Action  string    `json:"action"`
+	Service string    `json:"service"`
+	Reason  string    `json:"reason"`
+	Client  string    `json:"client,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// AuditLog remembers why services were woken and stopped.
+type AuditLog struct {
+	lock    sync.Mutex
+	size    int
+	entries []AuditEntry
+	file    *os.File
+}
+
+func NewAuditLog(config *AuditConfig) (a *AuditLog, err error) {
+	a = &AuditLog{size: 1000}
+	if config == nil {
+		return
+	}
+	if config.Size > 0 {
+		a.size = config.Size
+	}
+	if config.Path != "" {
+		a.file, err = os.OpenFile(config.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	}
+	return
+}
+
+func (a *AuditLog) Record(entry AuditEntry) {
+	entry.Time = time.Now()
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.entries = append(a.entries, entry)
+	if len(a.entries) > a.size {
+		a.entries = a.entries[len(a.entries)-a.size:]
+	}
+	if a.file != nil {
+		buf, err := json.Marshal(entry)
+		if err == nil {
+			_, err = a.file.Write(append(buf, '\n'))
+		}
+		if err != nil {
+			slog.Error("Error writing audit log", "err", err)
+		}
+	}
+}
+
+// Query returns entries matching the given service and action, either of
+// which may be empty for any, recorded after since.
+func (a *AuditLog) Query(service string, action string, since time.Time) []AuditEntry {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	entries := make([]AuditEntry, 0)
+	for _, entry := range a.entries {
+		if (service == "" || entry.Service == service) && (action == "" || entry.Action == action) && entry.Time.After(since) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// trigger is what set off a wake, carried along the launch's context.
+type trigger struct {
+	reason string
+	client string
+}
+
+type triggerKey struct{}
+
+func WithTrigger(ctx context.Context, reason string, client string) context.Context {
+	return context.WithValue(ctx, triggerKey{}, trigger{reason, client})
+}
+
+func (s *Server) Audit(ctx context.Context, action string, app Service, err error) {
+	t, ok := ctx.Value(triggerKey{}).(trigger)
+	if !ok {
+		t.reason = "unknown"
+	}
+	s.AuditReason(action, app, t.reason, t.client, err)
+}
+
+func (s *Server) AuditReason(action string, app Service, reason string, client string, err error) {
+	entry := AuditEntry{Action: action, Service: app.Name, Reason: reason, Client: client}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	s.AuditLog.Record(entry)
+}
@@ -0,0 +1,188 @@
+package fishingboat
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// HTTPAuth guards an http port before anything is woken or proxied, with
+// any combination of basic auth, JWTs, and forward auth.
+type HTTPAuth struct {
+	// htpasswd file of bcrypt or {SHA} hashes, reread when it changes
+	Htpasswd string `json:"htpasswd,omitempty"`
+	Realm    string `json:"realm,omitempty"`
+	// asked about every request, e.g. Authelia's /api/verify, and any 2xx
+	// lets it through. Otherwise its response goes back to the client.
+	ForwardURL string `json:"forwardURL,omitempty"`
+	// response headers of the forward auth endpoint copied onto the request
+	ForwardHeaders []string `json:"forwardHeaders,omitempty"`
+	// validate bearer tokens against a JWKS
+	JWT *JWTAuth `json:"jwt,omitempty"`
+}
+
+type htpasswd struct {
+	lock    sync.Mutex
+	modTime time.Time
+	users   map[string]string
+}
+
+// Htpasswd returns the cached htpasswd file at path, creating it if needed.
+func (s *Server) Htpasswd(path string) *htpasswd {
+	s.ServerLock.Lock()
+	defer s.ServerLock.Unlock()
+	h, ok := s.HtpasswdFiles[path]
+	if !ok {
+		h = &htpasswd{}
+		s.HtpasswdFiles[path] = h
+	}
+	return h
+}
+
+func (h *htpasswd) Check(path string, user string, password string) (bool, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	if h.users == nil || !info.ModTime().Equal(h.modTime) {
+		file, err := os.Open(path)
+		if err != nil {
+			return false, err
+		}
+		defer file.Close()
+		users := make(map[string]string)
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if name, hash, ok := strings.Cut(line, ":"); ok {
+				users[name] = hash
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return false, err
+		}
+		h.users = users
+		h.modTime = info.ModTime()
+	}
+
+	hash, ok := h.users[user]
+	if !ok {
+		return false, nil
+	}
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil, nil
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		return subtle.ConstantTimeCompare([]byte(hash[len("{SHA}"):]), []byte(base64.StdEncoding.EncodeToString(sum[:]))) == 1, nil
+	}
+	slog.Warn("Unsupported htpasswd hash, use bcrypt", "user", user)
+	return false, nil
+}
+
+// Authenticate checks a request against the service's auth, answering it
+// itself and returning false if it may not go through.
+func (s *Server) Authenticate(w http.ResponseWriter, r *http.Request, app Service) bool {
+	auth := app.Auth
+	if auth == nil {
+		return true
+	}
+	if auth.Htpasswd != "" {
+		user, password, ok := r.BasicAuth()
+		if ok {
+			var err error
+			ok, err = s.Htpasswd(auth.Htpasswd).Check(auth.Htpasswd, user, password)
+			if err != nil {
+				s.Logger.Error("Error reading htpasswd", "service", app.Name, "path", auth.Htpasswd, "err", err)
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+				return false
+			}
+			if !ok {
+				s.Logger.Info("Rejected basic auth", "service", app.Name, "user", user, "remote", r.RemoteAddr)
+			}
+		}
+		if !ok {
+			realm := auth.Realm
+			if realm == "" {
+				realm = app.Name
+			}
+			w.Header().Set("WWW-Authenticate", `Basic realm="`+realm+`"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return false
+		}
+	}
+	if auth.JWT != nil && !s.ValidateJWT(w, r, app) {
+		return false
+	}
+	if auth.ForwardURL != "" {
+		return s.ForwardAuth(w, r, app)
+	}
+	return true
+}
+
+// ForwardAuth asks the forward auth endpoint about r, the way Traefik and
+// nginx's auth_request do.
+func (s *Server) ForwardAuth(w http.ResponseWriter, r *http.Request, app Service) bool {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, app.Auth.ForwardURL, nil)
+	if err != nil {
+		s.Logger.Error("Error building forward auth request", "service", app.Name, "err", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return false
+	}
+	req.Header = r.Header.Clone()
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	req.Header.Set("X-Forwarded-Method", r.Method)
+	req.Header.Set("X-Forwarded-Proto", proto)
+	req.Header.Set("X-Forwarded-Host", r.Host)
+	req.Header.Set("X-Forwarded-Uri", r.URL.RequestURI())
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		req.Header.Set("X-Forwarded-For", host)
+	}
+	client := http.Client{
+		// pass redirects to a login page back to the client
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		s.Logger.Error("Error calling forward auth", "service", app.Name, "err", err)
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+		return false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		for _, header := range app.Auth.ForwardHeaders {
+			if value := resp.Header.Get(header); value != "" {
+				r.Header.Set(header, value)
+			}
+		}
+		return true
+	}
+	for header, values := range resp.Header {
+		w.Header()[header] = values
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+	return false
+}
@@ -0,0 +1,121 @@
+package fishingboat
+
+import (
+	"context"
+	"time"
+)
+
+// Autoscale drives how many of a service's maxReplicas take connections from
+// the total connection count instead of a fixed per-replica threshold.
+type Autoscale struct {
+	TargetConnections int `json:"targetConnections"`
+	// seconds to wait after scaling up before scaling up again
+	ScaleUpCooldown int `json:"scaleUpCooldown,omitempty"`
+	// seconds the load must stay lower before scaling down
+	ScaleDownStabilization int `json:"scaleDownStabilization,omitempty"`
+}
+
+type recommendation struct {
+	at       time.Time
+	replicas int
+}
+
+type autoscaler struct {
+	replicas        int
+	scaledUp        time.Time
+	recommendations []recommendation
+}
+
+func (s *Server) RunAutoscalers(ctx context.Context) {
+	for sleep(ctx, 5*time.Second) {
+		for _, app := range s.Config.Services {
+			if app.Autoscale == nil || app.Autoscale.TargetConnections <= 0 || app.MaxReplicas <= 1 {
+				continue
+			}
+			for _, replica := range s.Autoscale(app) {
+				s.Logger.Info("Scaling up", "service", app.Name, "replica", replica.Name)
+				s.AuditReason(AuditWake, replica, "autoscale", "", nil)
+				go s.WarmReplica(replica)
+			}
+		}
+	}
+}
+
+// Autoscale updates app's replica count and returns replicas to start.
+func (s *Server) Autoscale(app Service) (launch []Service) {
+	s.ServerLock.Lock()
+	defer s.ServerLock.Unlock()
+
+	a, ok := s.ServiceAutoscalers[app.Name]
+	if !ok {
+		a = &autoscaler{replicas: 1}
+		s.ServiceAutoscalers[app.Name] = a
+	}
+	replicas := s.Replicas(app)
+	var total uint
+	for _, replica := range replicas {
+		total += s.ConnCount(replica.Name)
+	}
+	target := uint(app.Autoscale.TargetConnections)
+	desired := int((total + target - 1) / target)
+	if desired < 1 {
+		desired = 1
+	}
+	if desired > app.MaxReplicas {
+		desired = app.MaxReplicas
+	}
+
+	now := s.Clock.Now()
+	window := time.Duration(app.Autoscale.ScaleDownStabilization) * time.Second
+	a.recommendations = append(a.recommendations, recommendation{at: now, replicas: desired})
+	for len(a.recommendations) > 1 && now.Sub(a.recommendations[0].at) > window {
+		a.recommendations = a.recommendations[1:]
+	}
+	// scale down only as far as the highest recommendation within the window
+	stabilized := 0
+	for _, r := range a.recommendations {
+		if r.replicas > stabilized {
+			stabilized = r.replicas
+		}
+	}
+
+	switch {
+	case desired > a.replicas:
+		if now.Sub(a.scaledUp) < time.Duration(app.Autoscale.ScaleUpCooldown)*time.Second {
+			return
+		}
+		launch = replicas[a.replicas:desired]
+		a.replicas = desired
+		a.scaledUp = now
+	case stabilized < a.replicas:
+		s.Logger.Info("Scaling down", "service", app.Name, "from", a.replicas, "to", stabilized)
+		// replicas past the count stop taking connections and cool down once drained
+		a.replicas = stabilized
+	}
+	return
+}
+
+// ReplicaLimit is how many of app's replicas may take connections. ServerLock must be held.
+func (s *Server) ReplicaLimit(app Service) int {
+	if app.Autoscale == nil || app.Autoscale.TargetConnections <= 0 {
+		return app.MaxReplicas
+	}
+	if a, ok := s.ServiceAutoscalers[app.Name]; ok {
+		return a.replicas
+	}
+	return 1
+}
+
+// WarmReplica starts a replica ahead of its first connection and gives it
+// the usual cooldown in case none arrives.
+func (s *Server) WarmReplica(replica Service) {
+	err := s.LaunchContainer(s.ctx, replica)
+	if err != nil {
+		s.Logger.Error("Error launching container", "service", replica.Name, "err", err)
+		return
+	}
+
+	if s.ConnCount(replica.Name) == 0 {
+		s.SetKillTime(replica.Name, s.Clock.Now().Add(time.Duration(replica.CoolDown)*time.Second))
+	}
+}
@@ -0,0 +1,97 @@
+package fishingboat
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// BackendTLS makes the proxy speak TLS to the container, for images that
+// only serve HTTPS or TLS.
+type BackendTLS struct {
+	// PEM bundle to verify the container's certificate with instead of the system roots
+	CA string `json:"ca,omitempty"`
+	// client certificate and key to present
+	Cert string `json:"cert,omitempty"`
+	Key  string `json:"key,omitempty"`
+	// name to verify, defaults to the backend host
+	ServerName         string `json:"serverName,omitempty"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"`
+}
+
+func (b *BackendTLS) Config() (config *tls.Config, err error) {
+	config = &tls.Config{
+		ServerName:         b.ServerName,
+		InsecureSkipVerify: b.InsecureSkipVerify,
+	}
+	if b.CA != "" {
+		var pem []byte
+		pem, err = os.ReadFile(b.CA)
+		if err != nil {
+			return
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", b.CA)
+		}
+	}
+	if b.Cert != "" || b.Key != "" {
+		var cert tls.Certificate
+		cert, err = tls.LoadX509KeyPair(b.Cert, b.Key)
+		if err != nil {
+			return
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return
+}
+
+// BackendTransport is the shared HTTP transport to app's container, so
+// keepalive connections to it are reused between requests.
+func (s *Server) BackendTransport(app Service) (transport *http.Transport, err error) {
+	s.ServerLock.Lock()
+	defer s.ServerLock.Unlock()
+	transport, ok := s.BackendTransports[app.Name]
+	if ok {
+		return
+	}
+	transport = http.DefaultTransport.(*http.Transport).Clone()
+	if app.BackendTLS != nil {
+		transport.TLSClientConfig, err = app.BackendTLS.Config()
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.BackendTransports[app.Name] = transport
+	return
+}
+
+// DialBackend connects to the container at addr, over TLS if the service asks for it.
+func (s *Server) DialBackend(ctx context.Context, app Service, addr string) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if err != nil || app.BackendTLS == nil {
+		return conn, err
+	}
+	transport, err := s.BackendTransport(app)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	config := transport.TLSClientConfig.Clone()
+	if config.ServerName == "" {
+		config.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+	tlsConn := tls.Client(conn, config)
+	handshakeCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := tlsConn.HandshakeContext(handshakeCtx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
@@ -0,0 +1,118 @@
+package fishingboat
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// BanConfig stops sources that keep opening connections without sending
+// anything, like port scanners, from waking services. HTTP ports count
+// connections that close before sending a request.
+type BanConfig struct {
+	// empty connections within window before a ban, defaults to 5
+	Strikes int `json:"strikes,omitempty"`
+	// seconds, defaults to 60
+	Window int `json:"window,omitempty"`
+	// seconds a ban lasts, defaults to 600
+	Duration int `json:"duration,omitempty"`
+}
+
+func (c *BanConfig) limits() (strikes int, window time.Duration, duration time.Duration) {
+	strikes, window, duration = 5, 60*time.Second, 600*time.Second
+	if c.Strikes > 0 {
+		strikes = c.Strikes
+	}
+	if c.Window > 0 {
+		window = time.Duration(c.Window) * time.Second
+	}
+	if c.Duration > 0 {
+		duration = time.Duration(c.Duration) * time.Second
+	}
+	return
+}
+
+type banRecord struct {
+	strikes []time.Time
+	until   time.Time
+}
+
+// prune drops strikes older than window, reporting whether the record has
+// nothing left worth keeping.
+func (r *banRecord) prune(now time.Time, window time.Duration) (empty bool) {
+	for len(r.strikes) > 0 && now.Sub(r.strikes[0]) > window {
+		r.strikes = r.strikes[1:]
+	}
+	return len(r.strikes) == 0 && now.After(r.until)
+}
+
+func sourceIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// Banned reports whether addr may not wake services right now.
+func (s *Server) Banned(addr string) bool {
+	if s.Config.Ban == nil {
+		return false
+	}
+	s.BanLock.Lock()
+	defer s.BanLock.Unlock()
+	record, ok := s.Bans[sourceIP(addr)]
+	return ok && time.Now().Before(record.until)
+}
+
+// Strike counts a connection from addr that closed without sending any
+// data, banning it once there are too many.
+func (s *Server) Strike(app Service, addr string) {
+	config := s.Config.Ban
+	if config == nil {
+		return
+	}
+	strikes, window, duration := config.limits()
+
+	ip := sourceIP(addr)
+	banned := false
+	func() {
+		s.BanLock.Lock()
+		defer s.BanLock.Unlock()
+		now := time.Now()
+		record, ok := s.Bans[ip]
+		if !ok {
+			record = &banRecord{}
+			s.Bans[ip] = record
+		}
+		record.prune(now, window)
+		record.strikes = append(record.strikes, now)
+		if len(record.strikes) >= strikes && now.After(record.until) {
+			record.until = now.Add(duration)
+			record.strikes = nil
+			banned = true
+		}
+	}()
+	if banned {
+		s.Logger.Warn("Banning source from waking services", "client", ip, "service", app.Name, "duration", duration)
+		s.AuditReason(AuditBan, app, "empty connections", ip, nil)
+	}
+}
+
+// SweepBans forgets sources whose strikes and bans have all run out, so
+// scans from many addresses don't pile up.
+func (s *Server) SweepBans(ctx context.Context) {
+	_, window, _ := s.Config.Ban.limits()
+	for sleep(ctx, window) {
+		func() {
+			s.BanLock.Lock()
+			defer s.BanLock.Unlock()
+			now := time.Now()
+			for source, record := range s.Bans {
+				if record.prune(now, window) {
+					delete(s.Bans, source)
+				}
+			}
+		}()
+	}
+}
@@ -0,0 +1,96 @@
+package fishingboat
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+type CircuitBreakerConfig struct {
+	// consecutive failed launches before the breaker opens, negative disables
+	Threshold int `json:"threshold,omitempty"`
+	// seconds to reject wakes for once open, doubling on each further failure
+	Backoff    int `json:"backoff,omitempty"`
+	MaxBackoff int `json:"maxBackoff,omitempty"`
+}
+
+type breaker struct {
+	failures  int
+	openUntil time.Time
+}
+
+func (c CircuitBreakerConfig) withDefaults() CircuitBreakerConfig {
+	if c.Threshold == 0 {
+		c.Threshold = 3
+	}
+	if c.Backoff <= 0 {
+		c.Backoff = 10
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = 300
+	}
+	return c
+}
+
+// CheckBreaker returns an error while app's breaker is open. ServerLock must be held.
+func (s *Server) CheckBreaker(app Service) error {
+	b, ok := s.ServiceBreakers[app.Name]
+	if !ok {
+		return nil
+	}
+	if wait := time.Until(b.openUntil); wait > 0 {
+		return fmt.Errorf("circuit breaker open after %d failed launches, retrying in %s", b.failures, wait.Round(time.Second))
+	}
+	return nil
+}
+
+// RecordLaunch updates app's breaker with a launch result. ServerLock must be held.
+func (s *Server) RecordLaunch(app Service, err error) {
+	if err == nil {
+		delete(s.ServiceBreakers, app.Name)
+		return
+	}
+	var resourceErr *InsufficientResourcesError
+	if errors.As(err, &resourceErr) {
+		// the service itself is fine, it just has to wait its turn
+		return
+	}
+	config := s.Config.CircuitBreaker.withDefaults()
+	if config.Threshold < 0 {
+		return
+	}
+	b, ok := s.ServiceBreakers[app.Name]
+	if !ok {
+		b = &breaker{}
+		s.ServiceBreakers[app.Name] = b
+	}
+	b.failures++
+	if b.failures < config.Threshold {
+		return
+	}
+	backoff := time.Duration(config.Backoff) * time.Second
+	maxBackoff := time.Duration(config.MaxBackoff) * time.Second
+	for i := config.Threshold; i < b.failures && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	b.openUntil = time.Now().Add(backoff)
+	s.Logger.Warn("Circuit breaker opened", "service", app.Name, "failures", b.failures, "backoff", backoff)
+}
+
+// BreakerState reports whether name's breaker is open, its failure streak, and when it next allows a wake.
+func (s *Server) BreakerState(name string) (open bool, failures int, retryIn time.Duration) {
+	s.ServerLock.RLock()
+	defer s.ServerLock.RUnlock()
+	b, ok := s.ServiceBreakers[name]
+	if !ok {
+		return
+	}
+	retryIn = time.Until(b.openUntil)
+	if retryIn < 0 {
+		retryIn = 0
+	}
+	return retryIn > 0, b.failures, retryIn
+}
@@ -0,0 +1,334 @@
+package fishingboat
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ClusterConfig lets several fishingboat nodes in front of the same Docker
+// hosts share connection counts, placements, and kill times through Redis,
+// so any node can wake a service and it is only scaled down once it is idle
+// on every node.
+type ClusterConfig struct {
+	// e.g. redis://localhost:6379/0
+	Redis string `json:"redis"`
+	// defaults to the hostname
+	Node string `json:"node,omitempty"`
+	// key prefix, defaults to "fishingboat"
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// how long a node's published usage outlives its last sync
+const clusterTTL = 10 * time.Second
+
+// remoteUsage is what the other nodes report for a service.
+type remoteUsage struct {
+	connections uint
+	lastUsed    time.Time
+	// the latest scale-down any node has scheduled
+	killTime time.Time
+	// the Docker node it was placed on, with nodes configured
+	node string
+}
+
+func (u remoteUsage) String() string {
+	var killTime int64
+	if !u.killTime.IsZero() {
+		killTime = u.killTime.Unix()
+	}
+	return fmt.Sprintf("%d,%d,%d,%s", u.connections, u.lastUsed.Unix(), killTime, u.node)
+}
+
+// add folds one node's report of a service into u.
+func (u *remoteUsage) add(value string) {
+	fields := strings.SplitN(value, ",", 4)
+	for len(fields) < 4 {
+		fields = append(fields, "")
+	}
+	count, _ := strconv.ParseUint(fields[0], 10, 64)
+	u.connections += uint(count)
+	if unix, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+		if t := time.Unix(unix, 0); t.After(u.lastUsed) {
+			u.lastUsed = t
+		}
+	}
+	if unix, err := strconv.ParseInt(fields[2], 10, 64); err == nil && unix > 0 {
+		if t := time.Unix(unix, 0); t.After(u.killTime) {
+			u.killTime = t
+		}
+	}
+	if fields[3] != "" {
+		u.node = fields[3]
+	}
+}
+
+// Cluster syncs with the other nodes. A nil *Cluster is a cluster of one.
+type Cluster struct {
+	client *redis.Client
+	node   string
+	prefix string
+	logger *slog.Logger
+}
+
+var unlockScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0`)
+
+func NewCluster(config ClusterConfig, logger *slog.Logger) (*Cluster, error) {
+	options, err := redis.ParseURL(config.Redis)
+	if err != nil {
+		return nil, err
+	}
+	c := &Cluster{client: redis.NewClient(options), node: config.Node, prefix: config.Prefix, logger: logger}
+	if c.node == "" {
+		c.node, err = os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.prefix == "" {
+		c.prefix = "fishingboat"
+	}
+	return c, nil
+}
+
+func (c *Cluster) key(parts ...string) string {
+	return c.prefix + ":" + strings.Join(parts, ":")
+}
+
+// Sync publishes this node's usage and reads back everyone else's.
+func (c *Cluster) Sync(ctx context.Context, local map[string]remoteUsage) (remote map[string]remoteUsage, err error) {
+	nodeKey := c.key("node", c.node)
+	fields := make(map[string]any, len(local))
+	for name, usage := range local {
+		fields[name] = usage.String()
+	}
+	pipe := c.client.TxPipeline()
+	pipe.Del(ctx, nodeKey)
+	if len(fields) > 0 {
+		pipe.HSet(ctx, nodeKey, fields)
+	}
+	pipe.Expire(ctx, nodeKey, clusterTTL)
+	pipe.SAdd(ctx, c.key("nodes"), c.node)
+	if _, err = pipe.Exec(ctx); err != nil {
+		return
+	}
+
+	nodes, err := c.client.SMembers(ctx, c.key("nodes")).Result()
+	if err != nil {
+		return
+	}
+	remote = make(map[string]remoteUsage)
+	for _, node := range nodes {
+		if node == c.node {
+			continue
+		}
+		var usages map[string]string
+		usages, err = c.client.HGetAll(ctx, c.key("node", node)).Result()
+		if err != nil {
+			return
+		}
+		if len(usages) == 0 {
+			// gone quiet for longer than the TTL
+			c.client.SRem(ctx, c.key("nodes"), node)
+			continue
+		}
+		for name, value := range usages {
+			usage := remote[name]
+			usage.add(value)
+			remote[name] = usage
+		}
+	}
+	return
+}
+
+// Usage reads what the other nodes report for one service right now,
+// rather than as of the last Sync.
+func (c *Cluster) Usage(ctx context.Context, name string) (usage remoteUsage, err error) {
+	nodes, err := c.client.SMembers(ctx, c.key("nodes")).Result()
+	if err != nil {
+		return
+	}
+	for _, node := range nodes {
+		if node == c.node {
+			continue
+		}
+		var value string
+		value, err = c.client.HGet(ctx, c.key("node", node), name).Result()
+		if err == redis.Nil {
+			err = nil
+			continue
+		}
+		if err != nil {
+			return
+		}
+		usage.add(value)
+	}
+	return
+}
+
+// how long a service's cluster lock lasts if its holder stops renewing it
+const lockTTL = 30 * time.Second
+
+// TryLock claims a service for a scale-down so two nodes don't stop it at
+// once, or returns false if another node already holds it. Like Lock, the
+// claim is kept alive until unlock is called.
+func (c *Cluster) TryLock(ctx context.Context, name string) (unlock func(), ok bool) {
+	if c == nil {
+		return func() {}, true
+	}
+	ok, err := c.client.SetNX(ctx, c.key("lock", name), c.node, lockTTL).Result()
+	if err != nil {
+		c.logger.Error("Error locking service in cluster", "service", name, "err", err)
+		return nil, false
+	}
+	if !ok {
+		return nil, false
+	}
+	return c.keepLocked(name), true
+}
+
+var extendScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("pexpire", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// Lock waits until it holds a service's cluster lock, the one launches and
+// scale-downs take, so only one node acts on it at a time. The lock is kept
+// alive until unlock is called, however long that takes.
+func (c *Cluster) Lock(ctx context.Context, name string) (unlock func(), err error) {
+	if c == nil {
+		return func() {}, nil
+	}
+	for {
+		var ok bool
+		ok, err = c.client.SetNX(ctx, c.key("lock", name), c.node, lockTTL).Result()
+		if err != nil {
+			return
+		}
+		if ok {
+			return c.keepLocked(name), nil
+		}
+		if !sleep(ctx, 100*time.Millisecond) {
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// keepLocked renews a lock this node just took until the returned unlock
+// is called.
+func (c *Cluster) keepLocked(name string) (unlock func()) {
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(lockTTL / 3):
+			}
+			err := extendScript.Run(context.Background(), c.client, []string{c.key("lock", name)}, c.node, lockTTL.Milliseconds()).Err()
+			if err != nil {
+				c.logger.Error("Error extending service lock in cluster", "service", name, "err", err)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		c.Unlock(name)
+	}
+}
+
+func (c *Cluster) Unlock(name string) {
+	if c == nil {
+		return
+	}
+	err := unlockScript.Run(context.Background(), c.client, []string{c.key("lock", name)}, c.node).Err()
+	if err != nil {
+		c.logger.Error("Error unlocking service in cluster", "service", name, "err", err)
+	}
+}
+
+// RunCluster keeps this node's view of the other nodes fresh.
+func (s *Server) RunCluster(ctx context.Context) {
+	for {
+		local := make(map[string]remoteUsage)
+		func() {
+			s.ConnLock.RLock()
+			defer s.ConnLock.RUnlock()
+			for name, c := range s.ServiceConns {
+				c.mutex.Lock()
+				local[name] = remoteUsage{connections: uint(c.count.Load()), lastUsed: c.lastUsed, killTime: c.killTime}
+				c.mutex.Unlock()
+			}
+		}()
+		for name, node := range s.Placements() {
+			usage := local[name]
+			usage.node = node
+			local[name] = usage
+		}
+		syncCtx, cancel := context.WithTimeout(ctx, clusterTTL/2)
+		remote, err := s.Cluster.Sync(syncCtx, local)
+		cancel()
+		if err != nil {
+			s.Logger.Error("Error syncing with cluster", "err", err)
+		} else {
+			func() {
+				s.ServerLock.Lock()
+				defer s.ServerLock.Unlock()
+				s.ClusterUsage = remote
+			}()
+		}
+		if !sleep(ctx, 1*time.Second) {
+			return
+		}
+	}
+}
+
+// RemoteBusy reports whether another node still has connections to app,
+// used it within its cooldown, or has yet to reach its own kill time for it.
+// ServerLock must be held.
+func (s *Server) RemoteBusy(app Service, now time.Time) bool {
+	usage, ok := s.ClusterUsage[app.Name]
+	if !ok {
+		return false
+	}
+	return usage.busy(app, now)
+}
+
+func (u remoteUsage) busy(app Service, now time.Time) bool {
+	return u.connections > 0 || now.Sub(u.lastUsed) < time.Duration(app.CoolDown)*time.Second || now.Before(u.killTime)
+}
+
+// RemoteBusyNow is RemoteBusy asked of Redis directly, for once the
+// service's cluster lock is held and a second old view won't do. It errs on
+// the side of busy if Redis can't be reached.
+func (s *Server) RemoteBusyNow(app Service) bool {
+	if s.Cluster == nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, clusterTTL/2)
+	defer cancel()
+	usage, err := s.Cluster.Usage(ctx, app.Name)
+	if err != nil {
+		s.Logger.Error("Error checking cluster usage", "service", app.Name, "err", err)
+		return true
+	}
+	return usage.busy(app, s.Clock.Now())
+}
+
+// RemotePlacement is the Docker node another node placed name on, or "".
+func (s *Server) RemotePlacement(name string) string {
+	s.ServerLock.RLock()
+	defer s.ServerLock.RUnlock()
+	return s.ClusterUsage[name].node
+}
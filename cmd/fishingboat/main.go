@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/briansemrau/fishingboat"
+)
+
+func main() {
+	configPath := flag.String("config", "services.json", "path to the services config")
+	logLevel := flag.String("log-level", "info", "minimum level to log: debug, info, warn, or error")
+	logFormat := flag.String("log-format", "text", "log output format: text or json")
+	runAs := flag.String("user", "", "drop to this user after binding the proxy ports")
+	logTarget := flag.String("log-target", fishingboat.LogStderr, "where to log: stderr, syslog, or journald")
+	logFile := fishingboat.LogFile{}
+	flag.StringVar(&logFile.Path, "log-file", "", "write logs to this file instead of stderr")
+	flag.IntVar(&logFile.MaxSize, "log-max-size", 100, "megabytes before the log file is rotated")
+	flag.IntVar(&logFile.MaxAge, "log-max-age", 0, "days to keep rotated log files, 0 for no limit")
+	flag.IntVar(&logFile.MaxBackups, "log-max-backups", 0, "rotated log files to keep, 0 for no limit")
+	flag.BoolVar(&logFile.Compress, "log-compress", false, "gzip rotated log files")
+	flag.DurationVar(&logFile.RotateEvery, "log-rotate-every", 0, "also rotate the log file this often, e.g. 24h")
+	flag.Parse()
+	inService := isWindowsService()
+	if inService {
+		// the service manager starts us in System32, paths in the config are
+		// relative to the config instead
+		if err := os.Chdir(filepath.Dir(*configPath)); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		*configPath = filepath.Base(*configPath)
+	}
+	if flag.Arg(0) == "service" {
+		// fishingboat [flags] service install|remove|start|stop
+		if err := serviceCommand(flag.Arg(1), *configPath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	var file *fishingboat.LogFile
+	if logFile.Path != "" {
+		file = &logFile
+	}
+	if err := fishingboat.SetupLogging(*logLevel, *logFormat, file, *logTarget); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	configBuf, err := os.ReadFile(*configPath)
+	if err != nil {
+		panic(err)
+	}
+	config := new(fishingboat.ServicesConfig)
+	if err = json.Unmarshal([]byte(configBuf), config); err != nil {
+		panic(err)
+	}
+
+	switch flag.Arg(0) {
+	case "":
+	case "status":
+		// fishingboat status [service]
+		if err = fishingboat.Status(config, flag.Arg(1)); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	case "logs":
+		// fishingboat logs [-f] [-tail N] service
+		logsFlags := flag.NewFlagSet("logs", flag.ExitOnError)
+		follow := logsFlags.Bool("f", false, "keep printing new output")
+		tail := logsFlags.Int("tail", -1, "lines to show from the end, all if negative")
+		logsFlags.Parse(flag.Args()[1:])
+		if logsFlags.NArg() != 1 {
+			fmt.Fprintln(os.Stderr, "usage: fishingboat logs [-f] [-tail N] service")
+			os.Exit(2)
+		}
+		if err = fishingboat.Logs(config, logsFlags.Arg(0), *tail, *follow, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	case "wake", "sleep", "disable", "enable":
+		// fishingboat wake service
+		// fishingboat sleep [-drain duration] service
+		// fishingboat disable|enable service
+		actionFlags := flag.NewFlagSet(flag.Arg(0), flag.ExitOnError)
+		drain := time.Duration(-1)
+		if flag.Arg(0) == "sleep" {
+			actionFlags.DurationVar(&drain, "drain", -1, "how long to let connections finish, defaults to the service's drainTimeout")
+		}
+		actionFlags.Parse(flag.Args()[1:])
+		if actionFlags.NArg() != 1 {
+			fmt.Fprintln(os.Stderr, "usage: fishingboat", flag.Arg(0), "service")
+			os.Exit(2)
+		}
+		switch flag.Arg(0) {
+		case "wake":
+			err = fishingboat.Wake(config, actionFlags.Arg(0))
+		case "sleep":
+			err = fishingboat.Sleep(config, actionFlags.Arg(0), drain)
+		case "disable":
+			err = fishingboat.Disable(config, actionFlags.Arg(0))
+		case "enable":
+			err = fishingboat.Enable(config, actionFlags.Arg(0))
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	case "state":
+		// fishingboat state export|import [file]
+		switch flag.Arg(1) {
+		case "export":
+			err = fishingboat.ExportState(config, flag.Arg(2))
+		case "import":
+			err = fishingboat.ImportState(config, flag.Arg(2))
+		default:
+			err = fmt.Errorf("usage: fishingboat state export|import [file]")
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	default:
+		fmt.Fprintln(os.Stderr, "unknown command", flag.Arg(0))
+		os.Exit(2)
+	}
+
+	if inService {
+		err = runService(func(ctx context.Context) error { return run(ctx, config, *runAs) })
+	} else {
+		// containers are left running on shutdown and adopted on the next start
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		err = run(ctx, config, *runAs)
+	}
+	if errors.Is(err, fishingboat.ErrSteppedDown) {
+		// the supervisor restarts us as the standby
+		os.Exit(1)
+	}
+	if err != nil {
+		slog.Error("Error starting server", "err", err)
+		panic(err)
+	}
+}
+
+func run(ctx context.Context, config *fishingboat.ServicesConfig, runAs string) (err error) {
+	server, err := fishingboat.NewServer(*config)
+	if err != nil {
+		return
+	}
+	server.User = runAs
+	if config.Tracing != nil {
+		var shutdown func(context.Context) error
+		shutdown, err = fishingboat.SetupTracing(config.Tracing)
+		if err != nil {
+			return
+		}
+		defer shutdown(context.Background())
+	}
+	return server.Start(ctx)
+}
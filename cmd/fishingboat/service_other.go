@@ -0,0 +1,20 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+func isWindowsService() bool {
+	return false
+}
+
+func runService(run func(ctx context.Context) error) error {
+	return run(context.Background())
+}
+
+func serviceCommand(action string, configPath string) error {
+	return errors.New("services are only managed by fishingboat on Windows, use systemd or similar instead")
+}
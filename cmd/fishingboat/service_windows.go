@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+const serviceName = "fishingboat"
+
+func isWindowsService() bool {
+	inService, err := svc.IsWindowsService()
+	return err == nil && inService
+}
+
+// serviceHandler runs the proxy under the service control manager until it
+// is asked to stop.
+type serviceHandler struct {
+	run func(ctx context.Context) error
+	err error
+}
+
+func (h *serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- h.run(ctx) }()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case h.err = <-done:
+			if h.err != nil {
+				return true, 1
+			}
+			return false, 0
+		case request := <-requests:
+			switch request.Cmd {
+			case svc.Interrogate:
+				status <- request.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				cancel()
+			}
+		}
+	}
+}
+
+func runService(run func(ctx context.Context) error) error {
+	handler := &serviceHandler{run: run}
+	if err := svc.Run(serviceName, handler); err != nil {
+		return err
+	}
+	return handler.err
+}
+
+// serviceCommand installs, removes, starts, or stops the Windows service.
+// The service is installed to run with the same flags it was installed with.
+func serviceCommand(action string, configPath string) (err error) {
+	m, err := mgr.Connect()
+	if err != nil {
+		return
+	}
+	defer m.Disconnect()
+
+	if action == "install" {
+		var exe string
+		exe, err = os.Executable()
+		if err != nil {
+			return
+		}
+		configPath, err = filepath.Abs(configPath)
+		if err != nil {
+			return
+		}
+		args := []string{"-config=" + configPath}
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name != "config" {
+				args = append(args, "-"+f.Name+"="+f.Value.String())
+			}
+		})
+		var s *mgr.Service
+		s, err = m.CreateService(serviceName, exe, mgr.Config{
+			DisplayName: "FishingBoat",
+			Description: "Starts containers when they are connected to and stops them when idle",
+			StartType:   mgr.StartAutomatic,
+		}, args...)
+		if err != nil {
+			return
+		}
+		return s.Close()
+	}
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return
+	}
+	defer s.Close()
+	switch action {
+	case "remove":
+		return s.Delete()
+	case "start":
+		return s.Start()
+	case "stop":
+		var status svc.Status
+		status, err = s.Control(svc.Stop)
+		for err == nil && status.State != svc.Stopped {
+			time.Sleep(500 * time.Millisecond)
+			status, err = s.Query()
+		}
+		return
+	}
+	return fmt.Errorf("usage: fishingboat service install|remove|start|stop")
+}
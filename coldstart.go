@@ -0,0 +1,61 @@
+package fishingboat
+
+import (
+	"time"
+)
+
+// how many recent cold starts are kept per service
+const coldStartHistory = 10
+
+// upper bounds in seconds of the cold start histogram buckets, with an implicit +Inf
+var coldStartBuckets = []float64{0.5, 1, 2, 5, 10, 30, 60, 120}
+
+// ColdStart breaks down how many milliseconds one launch took to become ready.
+type ColdStart struct {
+	At          time.Time `json:"at"`
+	TotalMs     int64     `json:"totalMs"`
+	PullMs      int64     `json:"pullMs"`
+	CreateMs    int64     `json:"createMs"`
+	StartMs     int64     `json:"startMs"`
+	ReadinessMs int64     `json:"readinessMs"`
+}
+
+// Histogram counts observations per bucket; Counts has one more entry than
+// Bounds for everything past the last bound.
+type Histogram struct {
+	Bounds []float64 `json:"bounds"`
+	Counts []uint64  `json:"counts"`
+	Sum    float64   `json:"sum"`
+	Count  uint64    `json:"count"`
+}
+
+func NewHistogram(bounds []float64) *Histogram {
+	return &Histogram{Bounds: bounds, Counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := 0
+	for i < len(h.Bounds) && v > h.Bounds[i] {
+		i++
+	}
+	h.Counts[i]++
+	h.Sum += v
+	h.Count++
+}
+
+// RecordColdStart keeps a launch's timings. ServerLock must be held.
+func (s *Server) RecordColdStart(app Service, cs ColdStart) {
+	coldStarts := append(s.ServiceColdStarts[app.Name], cs)
+	if len(coldStarts) > coldStartHistory {
+		coldStarts = coldStarts[len(coldStarts)-coldStartHistory:]
+	}
+	s.ServiceColdStarts[app.Name] = coldStarts
+
+	h, ok := s.ServiceColdStartHistograms[app.Name]
+	if !ok {
+		h = NewHistogram(coldStartBuckets)
+		s.ServiceColdStartHistograms[app.Name] = h
+	}
+	h.Observe(float64(cs.TotalMs) / 1000)
+	s.StatsD.Timing("cold_start", cs.TotalMs, "service:"+app.Name)
+}
@@ -0,0 +1,92 @@
+package fishingboat
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// what happens to connections over maxConnections
+const (
+	OverflowReject = "reject"
+	OverflowQueue  = "queue"
+)
+
+// ValidateConnectionCap checks the overflow action before any port is opened.
+func (s *Server) ValidateConnectionCap() error {
+	switch strings.ToLower(s.Config.OverflowAction) {
+	case None, OverflowReject, OverflowQueue:
+		return nil
+	}
+	return fmt.Errorf("unknown overflow action %q", s.Config.OverflowAction)
+}
+
+// limitListener makes every connection accepted on listener hold one of the
+// server's connection slots until it is closed, so a flood can't spawn
+// goroutines without bound.
+func (s *Server) limitListener(listener net.Listener) net.Listener {
+	if s.ConnSlots == nil {
+		return listener
+	}
+	return &limitedListener{Listener: listener, s: s, done: make(chan struct{})}
+}
+
+type limitedListener struct {
+	net.Listener
+	s         *Server
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *limitedListener) Accept() (net.Conn, error) {
+	queue := strings.ToLower(l.s.Config.OverflowAction) == OverflowQueue
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if queue {
+			// hold this one until a slot frees up, so the rest wait in the
+			// kernel's backlog
+			select {
+			case l.s.ConnSlots <- struct{}{}:
+			case <-l.done:
+				conn.Close()
+				return nil, net.ErrClosed
+			}
+		} else {
+			select {
+			case l.s.ConnSlots <- struct{}{}:
+			default:
+				l.s.Logger.Debug("Rejecting connection over the connection cap", "remote", conn.RemoteAddr().String())
+				l.s.StatsD.Count("connections.rejected", 1)
+				conn.Close()
+				continue
+			}
+		}
+		return &slotConn{Conn: conn, slots: l.s.ConnSlots}, nil
+	}
+}
+
+func (l *limitedListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// slotConn gives its connection slot back when closed.
+type slotConn struct {
+	net.Conn
+	slots     chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *slotConn) Close() error {
+	c.closeOnce.Do(func() { <-c.slots })
+	return c.Conn.Close()
+}
+
+// NetConn is the accepted connection, for copying on the socket directly.
+func (c *slotConn) NetConn() net.Conn {
+	return c.Conn
+}
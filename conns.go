@@ -0,0 +1,227 @@
+package fishingboat
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// connState is a service's proxied connections and pending scale-down. The
+// count is atomic and the rest has its own mutex, so proxying a connection
+// never waits on ServerLock.
+type connState struct {
+	count atomic.Int64
+
+	mutex sync.Mutex
+	// the service counted, which changes when a successor is promoted
+	name     string
+	lastUsed time.Time
+	// zero while no scale-down is scheduled
+	killTime time.Time
+}
+
+// done counts a connection closing, never going below zero. It reports
+// whether it was the last one, and false for ok if none were open.
+func (c *connState) done() (last bool, ok bool) {
+	for {
+		n := c.count.Load()
+		if n <= 0 {
+			return false, false
+		}
+		if c.count.CompareAndSwap(n, n-1) {
+			return n == 1, true
+		}
+	}
+}
+
+// conns returns name's connection state, creating it the first time.
+func (s *Server) conns(name string) *connState {
+	s.ConnLock.RLock()
+	c, ok := s.ServiceConns[name]
+	s.ConnLock.RUnlock()
+	if ok {
+		return c
+	}
+	s.ConnLock.Lock()
+	defer s.ConnLock.Unlock()
+	if c, ok = s.ServiceConns[name]; !ok {
+		c = &connState{name: name}
+		s.ServiceConns[name] = c
+	}
+	return c
+}
+
+// lookupConns is conns without creating anything, nil if name has none.
+func (s *Server) lookupConns(name string) *connState {
+	s.ConnLock.RLock()
+	defer s.ConnLock.RUnlock()
+	return s.ServiceConns[name]
+}
+
+// ConnCount is how many connections and dependents are holding name up.
+func (s *Server) ConnCount(name string) uint {
+	if c := s.lookupConns(name); c != nil {
+		return uint(c.count.Load())
+	}
+	return 0
+}
+
+// Touch marks name as just used.
+func (s *Server) Touch(name string) {
+	s.SetLastUsed(name, s.Clock.Now())
+}
+
+// SetLastUsed is Touch for a time other than now, like one restored from
+// saved state.
+func (s *Server) SetLastUsed(name string, t time.Time) {
+	c := s.conns(name)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.lastUsed = t
+}
+
+// LastUsed is when name last had a connection open or close, zero if never.
+func (s *Server) LastUsed(name string) time.Time {
+	c := s.lookupConns(name)
+	if c == nil {
+		return time.Time{}
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.lastUsed
+}
+
+// SetKillTime schedules name to be stopped at t and lets the reaper know so
+// it doesn't oversleep.
+func (s *Server) SetKillTime(name string, t time.Time) {
+	func() {
+		c := s.conns(name)
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		c.killTime = t
+	}()
+	s.wakeReaper()
+}
+
+// PostponeKillTime moves name's scale-down to t, unless a connection
+// cancelled it meanwhile.
+func (s *Server) PostponeKillTime(name string, t time.Time) {
+	func() {
+		c := s.conns(name)
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		if !c.killTime.IsZero() {
+			c.killTime = t
+		}
+	}()
+	s.wakeReaper()
+}
+
+// wakeReaper has the reaper look at the kill times again, so it doesn't
+// oversleep one that moved.
+func (s *Server) wakeReaper() {
+	select {
+	case s.reaperWake <- struct{}{}:
+	default:
+	}
+}
+
+// ClearKillTime cancels name's scale-down, if one is scheduled.
+func (s *Server) ClearKillTime(name string) {
+	c := s.lookupConns(name)
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.killTime = time.Time{}
+}
+
+// KillTime is when name is due to be stopped, if it is.
+func (s *Server) KillTime(name string) (time.Time, bool) {
+	c := s.lookupConns(name)
+	if c == nil {
+		return time.Time{}, false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.killTime, !c.killTime.IsZero()
+}
+
+// KillTimes is every scheduled scale-down, by service.
+func (s *Server) KillTimes() map[string]time.Time {
+	s.ConnLock.RLock()
+	defer s.ConnLock.RUnlock()
+	kills := make(map[string]time.Time)
+	for name, c := range s.ServiceConns {
+		c.mutex.Lock()
+		if !c.killTime.IsZero() {
+			kills[name] = c.killTime
+		}
+		c.mutex.Unlock()
+	}
+	return kills
+}
+
+// Acquire counts a connection (or request) against app, holding off its
+// cooldown, until the returned release is called. Ports with noRefcount
+// only make sure a cooldown is scheduled.
+func (s *Server) Acquire(app Service, port PortMapping) (release func()) {
+	c := s.conns(app.Name)
+	s.Touch(app.Name)
+	if port.NoRefcount {
+		if _, ok := s.KillTime(app.Name); !ok && c.count.Load() == 0 {
+			s.schedulePortKill(app, port)
+		}
+	} else {
+		c.count.Add(1)
+		s.CancelGroupKill(app)
+	}
+	return func() { s.release(c, app, port) }
+}
+
+// release undoes Acquire, starting the cooldown once nothing is left.
+func (s *Server) release(c *connState, app Service, port PortMapping) {
+	var name string
+	func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		c.lastUsed = s.Clock.Now()
+		name = c.name
+	}()
+	// the container may have taken over another name meanwhile
+	if name != app.Name {
+		if renamed := s.FindService(name); renamed != nil {
+			app = *renamed
+		}
+	}
+	if port.NoRefcount {
+		return
+	}
+	last, ok := c.done()
+	if !ok {
+		s.Logger.Warn("Connection was released but not held", "service", app.Name)
+	}
+	if last {
+		s.schedulePortKill(app, port)
+	}
+}
+
+// promoteConns hands the successor's connections and cooldown to app once
+// its container has taken over app's name. Anything still open on app's
+// old container counts against the old state, which nothing reads anymore.
+func (s *Server) promoteConns(successor string, app string) {
+	s.ConnLock.Lock()
+	defer s.ConnLock.Unlock()
+	c, ok := s.ServiceConns[successor]
+	if !ok {
+		return
+	}
+	func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		c.name = app
+	}()
+	s.ServiceConns[app] = c
+	delete(s.ServiceConns, successor)
+}
@@ -0,0 +1,86 @@
+package fishingboat
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ParseCpuList parses a cpuset list such as "0-3,8".
+func ParseCpuList(list string) ([]int, error) {
+	cpus := make([]int, 0)
+	for _, part := range strings.Split(list, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		first, last, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpu list %q", list)
+		}
+		end := start
+		if isRange {
+			end, err = strconv.Atoi(last)
+			if err != nil || end < start {
+				return nil, fmt.Errorf("invalid cpu list %q", list)
+			}
+		}
+		for cpu := start; cpu <= end; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
+
+func FormatCpuList(cpus []int) string {
+	parts := make([]string, 0, len(cpus))
+	for _, cpu := range cpus {
+		parts = append(parts, strconv.Itoa(cpu))
+	}
+	return strings.Join(parts, ",")
+}
+
+// AssignCpus picks cores from the server's cpu pool that no other running
+// service is pinned to, or "" if app doesn't ask for automatic pinning. The
+// cores are reserved for app straight away, so a launch racing this one
+// can't pick them too. ReleaseCpus gives them back if the launch fails.
+func (s *Server) AssignCpus(app Service) (string, error) {
+	if app.ResourceRequest == nil || app.ResourceRequest.PinCpus <= 0 || app.ResourceRequest.CpusetCpus != "" {
+		return "", nil
+	}
+	pool, err := ParseCpuList(s.Config.Resources.CpuPool)
+	if err != nil {
+		return "", err
+	}
+
+	s.TrackedResourcesLock.Lock()
+	defer s.TrackedResourcesLock.Unlock()
+	taken := make(map[int]bool)
+	for _, cpus := range s.CpuAllocations {
+		for _, cpu := range cpus {
+			taken[cpu] = true
+		}
+	}
+	free := make([]int, 0)
+	for _, cpu := range pool {
+		if !taken[cpu] {
+			free = append(free, cpu)
+		}
+	}
+	if len(free) < app.ResourceRequest.PinCpus {
+		return "", &InsufficientResourcesError{"pinned cpu"}
+	}
+	free = free[:app.ResourceRequest.PinCpus]
+	sort.Ints(free)
+	s.CpuAllocations[app.Name] = free
+	return FormatCpuList(free), nil
+}
+
+// ReleaseCpus drops the cores reserved for app.
+func (s *Server) ReleaseCpus(app Service) {
+	s.TrackedResourcesLock.Lock()
+	defer s.TrackedResourcesLock.Unlock()
+	delete(s.CpuAllocations, app.Name)
+}
@@ -0,0 +1,114 @@
+package fishingboat
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// CheckDependencies makes sure every dependency exists and that there are no cycles.
+func (s *Server) CheckDependencies() error {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("dependency cycle through service %s", name)
+		case visited:
+			return nil
+		}
+		app := s.FindService(name)
+		if app == nil {
+			return fmt.Errorf("unknown service %s", name)
+		}
+		state[name] = visiting
+		for _, dep := range app.DependsOn {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		return nil
+	}
+	for _, app := range s.Config.Services {
+		if err := visit(app.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Dependencies is every service app depends on, directly or through
+// another dependency.
+func (s *Server) Dependencies(app Service) map[string]bool {
+	deps := make(map[string]bool)
+	var visit func(names []string)
+	visit = func(names []string) {
+		for _, name := range names {
+			if deps[name] {
+				continue
+			}
+			deps[name] = true
+			if dep := s.FindService(name); dep != nil {
+				visit(dep.DependsOn)
+			}
+		}
+	}
+	visit(app.DependsOn)
+	return deps
+}
+
+func (s *Server) LaunchDependencies(ctx context.Context, app Service) (err error) {
+	for _, name := range app.DependsOn {
+		dep := s.FindService(name)
+		if dep == nil {
+			return fmt.Errorf("unknown dependency %s", name)
+		}
+		s.Logger.Info("Launching dependency", "service", app.Name, "dependency", name)
+		err = s.LaunchContainer(ctx, *dep)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Dependencies are refcounted like connections while a dependent is running,
+// so they only start their cooldown once nothing depends on them.
+func (s *Server) AcquireDependencies(app Service) {
+	s.ServerLock.Lock()
+	defer s.ServerLock.Unlock()
+	if s.DependencyHolds[app.Name] {
+		return
+	}
+	s.DependencyHolds[app.Name] = true
+	for _, name := range app.DependsOn {
+		s.conns(name).count.Add(1)
+		s.ClearKillTime(name)
+	}
+}
+
+func (s *Server) ReleaseDependencies(app Service) {
+	s.ServerLock.Lock()
+	defer s.ServerLock.Unlock()
+	if !s.DependencyHolds[app.Name] {
+		return
+	}
+	delete(s.DependencyHolds, app.Name)
+	for _, name := range app.DependsOn {
+		last, ok := s.conns(name).done()
+		if !ok {
+			s.Logger.Warn("Dependency was released but not held", "service", app.Name, "dependency", name)
+			continue
+		}
+		if last {
+			dep := s.FindService(name)
+			s.SetKillTime(name, s.Clock.Now().Add(time.Duration(dep.CoolDown)*time.Second))
+		}
+	}
+}
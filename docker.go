@@ -0,0 +1,96 @@
+package fishingboat
+
+import (
+	"context"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// DockerHost returns the shared client for a Docker host, or the local
+// daemon if host is empty, connecting the first time it is asked for.
+// Clients stay open for the life of the server and mustn't be closed.
+func (s *Server) DockerHost(host string) (cli *client.Client, err error) {
+	s.DockerLock.Lock()
+	defer s.DockerLock.Unlock()
+	if cli, ok := s.DockerClients[host]; ok {
+		return cli, nil
+	}
+	cli, err = s.Runtime(host)
+	if err != nil {
+		return
+	}
+	s.DockerClients[host] = cli
+	return
+}
+
+// WatchDocker pings every Docker host in use, logging when one goes away
+// and negotiating the API version again when it comes back, in case the
+// daemon was upgraded in between.
+func (s *Server) WatchDocker(ctx context.Context) {
+	down := make(map[string]bool)
+	for sleep(ctx, 10*time.Second) {
+		clients := make(map[string]*client.Client)
+		func() {
+			s.DockerLock.Lock()
+			defer s.DockerLock.Unlock()
+			for host, cli := range s.DockerClients {
+				clients[host] = cli
+			}
+		}()
+		for host, cli := range clients {
+			pingCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			ping, err := cli.Ping(pingCtx)
+			cancel()
+			if err != nil {
+				if !down[host] && ctx.Err() == nil {
+					s.Logger.Error("Lost connection to Docker", "host", host, "err", err)
+				}
+				down[host] = true
+				continue
+			}
+			if down[host] {
+				cli.NegotiateAPIVersionPing(ping)
+				s.Logger.Info("Reconnected to Docker", "host", host, "api", cli.ClientVersion())
+				delete(down, host)
+			}
+		}
+	}
+}
+
+// CloseDocker closes the shared clients once the server is done.
+func (s *Server) CloseDocker() {
+	s.DockerLock.Lock()
+	defer s.DockerLock.Unlock()
+	for host, cli := range s.DockerClients {
+		cli.Close()
+		delete(s.DockerClients, host)
+	}
+}
+
+func (s *Server) dockerTimeout() time.Duration {
+	if s.Config.DockerTimeout > 0 {
+		return time.Duration(s.Config.DockerTimeout) * time.Second
+	}
+	return 30 * time.Second
+}
+
+// dockerContext bounds one Docker API call made on behalf of ctx, so a hung
+// daemon can't hold a service's lock forever.
+func (s *Server) dockerContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, s.dockerTimeout())
+}
+
+// stopContext is dockerContext for calls that first wait out a stop timeout,
+// docker's default of 10 seconds if it is unset. A negative stop timeout
+// waits as long as the container takes.
+func (s *Server) stopContext(ctx context.Context, stopTimeout *int) (context.Context, context.CancelFunc) {
+	grace := 10 * time.Second
+	if stopTimeout != nil {
+		if *stopTimeout < 0 {
+			return context.WithCancel(ctx)
+		}
+		grace = time.Duration(*stopTimeout) * time.Second
+	}
+	return context.WithTimeout(ctx, s.dockerTimeout()+grace)
+}
@@ -0,0 +1,83 @@
+package fishingboat
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// readEnvFile parses a docker --env-file style file: KEY=value lines, with
+// blank lines and # comments skipped. A bare KEY takes its value from our own
+// environment, and is dropped if we don't have it.
+func readEnvFile(path string) (vars [][2]string, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(text, "=")
+		if key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("%s:%d: invalid variable name %q", path, line, key)
+		}
+		if !ok {
+			value, ok = os.LookupEnv(key)
+			if !ok {
+				continue
+			}
+		}
+		vars = append(vars, [2]string{key, value})
+	}
+	err = scanner.Err()
+	return
+}
+
+// ContainerEnv merges a service's env files and env map over the Env of its
+// container config. Later sources win.
+func ContainerEnv(app Service) (env []string, err error) {
+	if app.Config != nil {
+		env = app.Config.Env
+	}
+	if len(app.EnvFiles) == 0 && len(app.Env) == 0 {
+		return
+	}
+	// don't share the list with the service config
+	env = slices.Clone(env)
+	set := func(key, value string) {
+		entry := key + "=" + value
+		for i := range env {
+			if k, _, _ := strings.Cut(env[i], "="); k == key {
+				env[i] = entry
+				return
+			}
+		}
+		env = append(env, entry)
+	}
+	for _, path := range app.EnvFiles {
+		var vars [][2]string
+		vars, err = readEnvFile(path)
+		if err != nil {
+			return
+		}
+		for _, v := range vars {
+			set(v[0], v[1])
+		}
+	}
+	// sorted so the spec hash doesn't change between runs
+	keys := make([]string, 0, len(app.Env))
+	for key := range app.Env {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		set(key, app.Env[key])
+	}
+	return
+}
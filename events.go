@@ -0,0 +1,123 @@
+package fishingboat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	EventStarted          = "started"
+	EventStopped          = "stopped"
+	EventLaunchFailed     = "launchFailed"
+	EventResourceRejected = "resourceRejected"
+)
+
+// Webhook receives lifecycle events as JSON POSTs.
+type Webhook struct {
+	URL string `json:"url"`
+	// only these events, or all of them if empty
+	Events  []string `json:"events,omitempty"`
+	Retries int      `json:"retries,omitempty"`
+	Timeout int      `json:"timeout,omitempty"`
+}
+
+type Event struct {
+	Event   string    `json:"event"`
+	Service string    `json:"service"`
+	Time    time.Time `json:"time"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// wantsEvent reports whether an events filter, which is empty for all events, includes event.
+func wantsEvent(events []string, event string) bool {
+	if len(events) == 0 {
+		return true
+	}
+	for _, e := range events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Emit sends an event to every interested webhook in the background.
+func (s *Server) Emit(event string, app Service, cause error) {
+	e := Event{Event: event, Service: app.Name, Time: time.Now()}
+	s.StatsD.Count("events", 1, "service:"+app.Name, "event:"+event)
+	if cause != nil {
+		e.Error = cause.Error()
+	}
+	for _, webhook := range s.Config.Webhooks {
+		if wantsEvent(webhook.Events, event) {
+			go s.Deliver(webhook, e)
+		}
+	}
+	s.Notify(app, e)
+}
+
+func PostJSON(url string, body []byte, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %s", resp.Status)
+	}
+	return nil
+}
+
+// ReportLaunchResult emits and audits a failed launch, telling apart ones
+// that just didn't fit in the resource limits.
+func (s *Server) ReportLaunchResult(ctx context.Context, app Service, err error) {
+	if err == nil {
+		return
+	}
+	var resourceErr *InsufficientResourcesError
+	if errors.As(err, &resourceErr) {
+		s.Emit(EventResourceRejected, app, err)
+		s.Audit(ctx, AuditReject, app, err)
+	} else {
+		s.Emit(EventLaunchFailed, app, err)
+		s.Audit(ctx, AuditLaunchFailed, app, err)
+	}
+}
+
+// Deliver posts an event, retrying with exponential backoff.
+func (s *Server) Deliver(webhook Webhook, e Event) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		s.Logger.Error("Error encoding event", "err", err)
+		return
+	}
+	timeout := 10 * time.Second
+	if webhook.Timeout > 0 {
+		timeout = time.Duration(webhook.Timeout) * time.Second
+	}
+	backoff := 1 * time.Second
+	for attempt := 0; ; attempt++ {
+		err = PostJSON(webhook.URL, body, timeout)
+		if err == nil {
+			return
+		}
+		if attempt >= webhook.Retries {
+			s.Logger.Error("Error delivering event", "service", e.Service, "event", e.Event, "url", webhook.URL, "err", err)
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
@@ -1,35 +1,138 @@
-package main
+package fishingboat
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
-	"math/rand"
+	"log/slog"
+	"maps"
 	"net"
-	"os"
+	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
 	"github.com/docker/go-connections/nat"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Resources struct {
 	MilliCPU    int `json:"mcpu"`
 	MemoryMi    int `json:"memoryMi"`
 	GpuMemoryMi int `json:"gpuMemoryMi"`
+
+	CpusetCpus string `json:"cpusetCpus,omitempty"`
+	CpusetMems string `json:"cpusetMems,omitempty"`
+	// number of cores to pin exclusively from the server's cpu pool
+	PinCpus int `json:"pinCpus,omitempty"`
+
+	BlkioWeight     uint16       `json:"blkioWeight,omitempty"`
+	DeviceReadBps   []BlkioLimit `json:"deviceReadBps,omitempty"`
+	DeviceWriteBps  []BlkioLimit `json:"deviceWriteBps,omitempty"`
+	DeviceReadIOps  []BlkioLimit `json:"deviceReadIOps,omitempty"`
+	DeviceWriteIOps []BlkioLimit `json:"deviceWriteIOps,omitempty"`
+
+	PidsLimit int64 `json:"pidsLimit,omitempty"`
+
+	// memory plus swap, -1 for unlimited swap. Defaults to no swap when memory is limited.
+	MemorySwapMi     int    `json:"memorySwapMi,omitempty"`
+	MemorySwappiness *int64 `json:"memorySwappiness,omitempty"`
+
+	// disabling the OOM killer can hang the host under memory pressure
+	OomKillDisable bool `json:"oomKillDisable,omitempty"`
+	OomScoreAdj    int  `json:"oomScoreAdj,omitempty"`
+}
+
+// BlkioLimit throttles a block device, e.g. /dev/sda, in bytes or operations per second.
+type BlkioLimit struct {
+	Path string `json:"path"`
+	Rate uint64 `json:"rate"`
 }
 
 type PortMapping struct {
 	ContainerPort int   `json:"containerPort"`
 	HostPorts     []int `json:"hostPorts"`
+	// a range like "27015-27020" instead of containerPort, mapped to the
+	// same host ports or to each of hostPortRanges
+	ContainerPorts string   `json:"containerPorts,omitempty"`
+	HostPortRanges []string `json:"hostPortRanges,omitempty"`
+	// tcp (default) or http, which proxies and counts individual requests.
+	// udp and sctp ports aren't proxied yet: the container publishes them on
+	// hostPorts itself, so their traffic doesn't wake the service or keep it
+	// up.
+	Protocol string `json:"protocol,omitempty"`
+	// cooldown after the last connection on this port, instead of the service's
+	CoolDown *int `json:"cooldown,omitempty"`
+	// connections here never keep the service up, e.g. an admin console
+	NoRefcount bool `json:"noRefcount,omitempty"`
+	// publish the container port on the same port of the service's backend
+	// IP instead of a free one, for protocols that carry port numbers like
+	// FTP or SIP. The backend IP has to be free for it, e.g. a dedicated
+	// hostIP.
+	Transparent bool `json:"transparent,omitempty"`
+	// overrides the service's proxy tuning
+	Proxy *ProxyTuning `json:"proxy,omitempty"`
+}
+
+// Direct reports whether the container publishes the port itself instead
+// of fishingboat proxying it.
+func (p PortMapping) Direct() bool {
+	protocol := strings.ToLower(p.Protocol)
+	return protocol == ProtocolUDP || protocol == ProtocolSCTP
+}
+
+// Transport is the port's Docker protocol, tcp for everything proxied.
+func (p PortMapping) Transport() string {
+	if p.Direct() {
+		return strings.ToLower(p.Protocol)
+	}
+	return ProtocolTCP
+}
+
+// ValidatePorts rejects directly published and transparent ports on
+// services that can run more than one container at once, which would fight
+// over the host ports, and transparent ports that would collide with the
+// proxy's own.
+func (s *Server) ValidatePorts() error {
+	for _, app := range s.Config.Services {
+		multiple := app.MaxReplicas > 1 || app.WarmPool > 0 || strings.ToLower(app.UpdateStrategy) == UpdateBlueGreen
+		backendIP := s.Config.ServiceHostIP
+		if app.HostIP != "" {
+			backendIP = app.HostIP
+		}
+		for _, port := range app.Ports {
+			if (port.Direct() || port.Transparent) && multiple {
+				return fmt.Errorf("service %s: port %d can't be published by replicas, a warm pool, or blue/green updates", app.Name, port.ContainerPort)
+			}
+			if t := proxyTuning(app, port); t != nil && min(t.BufferSize, t.UpstreamBufferSize, t.DownstreamBufferSize) < 0 {
+				return fmt.Errorf("service %s: port %d has a negative proxy buffer size", app.Name, port.ContainerPort)
+			}
+			if !port.Transparent {
+				continue
+			}
+			if port.Direct() {
+				return fmt.Errorf("service %s: %s port %d is already published as is", app.Name, port.Transport(), port.ContainerPort)
+			}
+			if ip := net.ParseIP(backendIP); len(s.Config.Nodes) == 0 && (ip == nil || ip.IsUnspecified()) {
+				return fmt.Errorf("service %s: transparent port %d needs a backend IP", app.Name, port.ContainerPort)
+			}
+			if backendIP == s.Config.ProxyIP || net.ParseIP(s.Config.ProxyIP).IsUnspecified() || s.Config.ProxyIP == "" {
+				if slices.Contains(port.HostPorts, port.ContainerPort) {
+					return fmt.Errorf("service %s: transparent port %d is also a proxy port on the same address", app.Name, port.ContainerPort)
+				}
+			}
+		}
+	}
+	return nil
 }
 
 const (
@@ -39,6 +142,18 @@ const (
 	IfNotPresent = "ifnotpresent"
 )
 
+const (
+	IdleStop       = "stop"
+	IdleRemove     = "remove"
+	IdlePause      = "pause"
+	IdleCheckpoint = "checkpoint"
+)
+
+var ErrNoContainer = errors.New("container does not exist")
+
+// name of the CRIU checkpoint taken when a service goes idle
+const idleCheckpointID = "fishingboat-idle"
+
 type Service struct {
 	Name            string        `json:"name"`
 	ResourceRequest *Resources    `json:"resources,omitempty"`
@@ -49,13 +164,106 @@ type Service struct {
 	PullPolicy string `json:"pullPolicy,omitempty"`
 	HostIP     string `json:"hostIP,omitempty"`
 
-	Cmd        []string              `json:"cmd,omitempty"`
+	// cmd and env can have placeholders like {{.HostPort 25565}},
+	// {{.HostIP}}, and {{.ServiceName}}
+	Cmd []string `json:"cmd,omitempty"`
+	// merged into config.env. env overrides envFiles, which are read in order.
+	Env        map[string]string     `json:"env,omitempty"`
+	EnvFiles   []string              `json:"envFiles,omitempty"`
 	Config     *container.Config     `json:"config,omitempty"`
 	HostConfig *container.HostConfig `json:"hostConfig,omitempty"`
+	Security   *Security             `json:"security,omitempty"`
+	Mounts     []Mount               `json:"mounts,omitempty"`
+	// networks to attach to instead of the default bridge
+	Networks []NetworkAttachment `json:"networks,omitempty"`
+	// overrides the config-wide hardened setting
+	Hardened *bool `json:"hardened,omitempty"`
+
+	Group     string      `json:"group,omitempty"`
+	Priority  int         `json:"priority,omitempty"`
+	Schedules []Schedule  `json:"schedules,omitempty"`
+	DependsOn []string    `json:"dependsOn,omitempty"`
+	Hooks     *Hooks      `json:"hooks,omitempty"`
+	Gpus      *GpuRequest `json:"gpus,omitempty"`
+
+	MinUptime       int    `json:"minUptime,omitempty"`
+	MaxLifetime     int    `json:"maxLifetime,omitempty"`
+	IdleAction      string `json:"idleAction,omitempty"`
+	StopSignal      string `json:"stopSignal,omitempty"`
+	StopTimeout     *int   `json:"stopTimeout,omitempty"`
+	UnhealthyAction string `json:"unhealthyAction,omitempty"`
+	// no, always, unless-stopped, or on-failure[:retries]. fishingboat turns
+	// it off while the service is scaled down.
+	RestartPolicy string `json:"restartPolicy,omitempty"`
+	// overrides the image's HEALTHCHECK
+	Healthcheck  *Healthcheck `json:"healthcheck,omitempty"`
+	DrainTimeout int          `json:"drainTimeout,omitempty"`
+	// dial the container over TLS
+	BackendTLS *BackendTLS `json:"backendTLS,omitempty"`
+	// buffer sizes and socket options for its proxied tcp ports
+	Proxy *ProxyTuning `json:"proxy,omitempty"`
+	// checked before waking for or proxying http ports
+	Auth *HTTPAuth `json:"auth,omitempty"`
+	// lets external jobs hold the service awake through the admin API
+	// without the admin token
+	HoldToken string `json:"holdToken,omitempty"`
+	// with idleMode traffic, scale down after idleTimeout seconds (default
+	// cooldown) without any bytes, even if clients keep connections open.
+	// With idleMode cpu, also wait until usage has stayed under idleMcpu for
+	// the whole cooldown (needs statsInterval).
+	IdleMode    string `json:"idleMode,omitempty"`
+	IdleTimeout int    `json:"idleTimeout,omitempty"`
+	IdleMcpu    int    `json:"idleMcpu,omitempty"`
+	// asked before stopping, an answer above zero defers it by a cooldown
+	IdleProbe *Hook `json:"idleProbe,omitempty"`
+
+	// start more replicas once each has replicaConnections connections
+	MaxReplicas        int        `json:"maxReplicas,omitempty"`
+	ReplicaConnections int        `json:"replicaConnections,omitempty"`
+	LoadBalancing      string     `json:"loadBalancing,omitempty"`
+	Autoscale          *Autoscale `json:"autoscale,omitempty"`
+	// containers kept paused (or stopped with warmPoolAction stop) ready to wake
+	WarmPool       int    `json:"warmPool,omitempty"`
+	WarmPoolAction string `json:"warmPoolAction,omitempty"`
+	// recreate (default) waits for idle, blueGreen swaps containers under load
+	UpdateStrategy string `json:"updateStrategy,omitempty"`
+	// stream the container's output into ours or a file
+	Logs *ContainerLogs `json:"logs,omitempty"`
+	// overrides the config-wide logDriver
+	LogDriver *LogDriver `json:"logDriver,omitempty"`
+	// chat notifications when the service wakes, stops, or fails to start
+	Notify []Notifier `json:"notify,omitempty"`
+	// send reconnecting clients back to the same replica, by sourceIP or,
+	// on HTTP ports, by cookie
+	SessionAffinity string `json:"sessionAffinity,omitempty"`
+	AffinityTimeout int    `json:"affinityTimeout,omitempty"`
+	// set on derived replicas
+	Replica   int    `json:"-"`
+	ReplicaOf string `json:"-"`
+
+	StartupQueueDepth   int    `json:"startupQueueDepth,omitempty"`
+	StartupTimeout      int    `json:"startupTimeout,omitempty"`
+	ResourcePolicy      string `json:"resourcePolicy,omitempty"`
+	ResourceWaitTimeout int    `json:"resourceWaitTimeout,omitempty"`
 }
 
 type ServerResourceLimits struct {
 	Limits Resources `json:"allocationLimits"`
+	// derive allocationLimits from the host, minus what is reserved for everything else
+	AutoDetect bool      `json:"autoDetect,omitempty"`
+	Reserved   Resources `json:"reserved,omitempty"`
+	// GPU indices or UUIDs to spread services requesting a count of GPUs over
+	Gpus []string `json:"gpus,omitempty"`
+	// cores to pin services asking for pinCpus to, e.g. "4-11"
+	CpuPool string `json:"cpuPool,omitempty"`
+	// ratios to schedule beyond the allocation limits, e.g. 1.5 for 150%
+	Overcommit Overcommit `json:"overcommit,omitempty"`
+}
+
+type Overcommit struct {
+	MilliCPU    float64 `json:"mcpu,omitempty"`
+	MemoryMi    float64 `json:"memoryMi,omitempty"`
+	GpuMemoryMi float64 `json:"gpuMemoryMi,omitempty"`
 }
 
 type ServicesConfig struct {
@@ -63,6 +271,71 @@ type ServicesConfig struct {
 	ServiceHostIP string               `json:"serviceHostIP"`
 	Resources     ServerResourceLimits `json:"resources"`
 	Services      []Service            `json:"services"`
+	// address clients reach the proxy at, for {{.HostIP}} in a service's cmd
+	// and env. Defaults to proxyIP, which mustn't be a wildcard then.
+	AdvertiseIP string `json:"advertiseIP,omitempty"`
+	// place services across these Docker hosts instead of the local one
+	Nodes []DockerNode `json:"nodes,omitempty"`
+	// what managed containers are called, defaults to <service>-goscalezero
+	Naming *NamingConfig `json:"naming,omitempty"`
+	// log driver for every service that doesn't set its own, instead of the
+	// daemon's default
+	LogDriver *LogDriver `json:"logDriver,omitempty"`
+	// remove stopped containers labelled for services no longer configured
+	RemoveOrphans bool `json:"removeOrphans,omitempty"`
+	// most connections proxied at once across every service, 0 for no limit
+	MaxConnections int `json:"maxConnections,omitempty"`
+	// what happens to connections over maxConnections: reject (default)
+	// closes them straight away, queue leaves them waiting to be accepted
+	OverflowAction string `json:"overflowAction,omitempty"`
+
+	// seconds between checks for updated images, 0 disables
+	ImageUpdateInterval int `json:"imageUpdateInterval,omitempty"`
+
+	CircuitBreaker CircuitBreakerConfig `json:"circuitBreaker,omitempty"`
+
+	// stop least recently used idle services when a wake doesn't fit
+	EvictIdle bool `json:"evictIdle,omitempty"`
+	// let higher priority wakes stop lower priority services with connections
+	PreemptActive bool `json:"preemptActive,omitempty"`
+
+	// seconds between docker stats samples of running containers, 0 disables
+	StatsInterval int `json:"statsInterval,omitempty"`
+	// read-only rootfs, no capabilities, and no-new-privileges for every
+	// service that doesn't opt out
+	Hardened bool `json:"hardened,omitempty"`
+	// longest the reaper sleeps between checks for idle services, defaults to 10
+	ReapInterval int `json:"reapInterval,omitempty"`
+	// seconds between checks that what is tracked matches Docker, defaults
+	// to 30, negative disables
+	ReconcileInterval int `json:"reconcileInterval,omitempty"`
+	// seconds a single Docker API call may take before it is abandoned,
+	// defaults to 30. Stops also get the service's stop timeout.
+	DockerTimeout int `json:"dockerTimeout,omitempty"`
+	// also sample video memory with nvidia-smi
+	GpuStats bool `json:"gpuStats,omitempty"`
+
+	Admin *AdminConfig `json:"admin,omitempty"`
+	// export traces of wakes and connections over OTLP
+	Tracing *TracingConfig `json:"tracing,omitempty"`
+	// log every proxied connection
+	AccessLog *AccessLogConfig `json:"accessLog,omitempty"`
+	// lifecycle events are posted to these
+	Webhooks []Webhook `json:"webhooks,omitempty"`
+	// history of scaling decisions
+	Audit *AuditConfig `json:"audit,omitempty"`
+	// push metrics to a statsd or DogStatsD agent
+	StatsD *StatsDConfig `json:"statsd,omitempty"`
+	// keep port scanners from waking services
+	Ban *BanConfig `json:"ban,omitempty"`
+	// share connection counts with other nodes
+	Cluster *ClusterConfig `json:"cluster,omitempty"`
+	// run as the active or standby half of a pair
+	HA *HAConfig `json:"ha,omitempty"`
+	// tell peers on other edge IPs where services are running
+	Gossip *GossipConfig `json:"gossip,omitempty"`
+	// remember port maps, allocations, and timers across restarts
+	State *StateConfig `json:"state,omitempty"`
 }
 
 type Server struct {
@@ -71,101 +344,440 @@ type Server struct {
 	ServerLock sync.RWMutex
 
 	ServiceProxyHostPortMap map[string]map[int]int
-	ServiceConnCount        map[string]uint
-	ServiceKillTime         map[string]time.Time
+	// container each service's port map was read from
+	ServicePortsContainer map[string]string
+	// running services holding a connection on each of their dependencies
+	DependencyHolds map[string]bool
+	// connections and scale-downs, kept off ServerLock
+	ConnLock                   sync.RWMutex
+	ServiceConns               map[string]*connState
+	ServiceWarmUntil           map[string]time.Time
+	ServiceStartTime           map[string]time.Time
+	ServiceRecreatePending     map[string]bool
+	ServiceRemediating         map[string]bool
+	ServiceStartups            map[string]*startup
+	ServiceBreakers            map[string]*breaker
+	ServiceNextReplica         map[string]int
+	ServiceAutoscalers         map[string]*autoscaler
+	ServiceAffinity            map[string]map[string]affinity
+	ServiceSuccessors          map[string]string
+	ServiceColdStarts          map[string][]ColdStart
+	ServiceColdStartHistograms map[string]*Histogram
+	NotifyLastSent             map[string]time.Time
+	ServiceLastTraffic         map[string]*atomic.Int64
+	ServiceLastBusy            map[string]time.Time
+	HtpasswdFiles              map[string]*htpasswd
+	KeySets                    map[string]*jwks
+	ClusterUsage               map[string]remoteUsage
+	BackendTransports          map[string]*http.Transport
+
+	// sources with empty connections, kept off ServerLock
+	BanLock sync.Mutex
+	Bans    map[string]*banRecord
+
+	// the reaper sleeps until the next kill time, or until one changes
+	Clock      Clock
+	reaperWake chan struct{}
 
 	TrackedResourcesLock sync.RWMutex
 	TrackedResources     Resources
+	// actual usage sampled from docker stats and nvidia-smi
+	MeasuredResources map[string]Resources
+	MeasuredGpu       *GpuMemory
+	// GPU device IDs held by each running service
+	GpuAllocations map[string][]string
+	// pinned cores held by each running service
+	CpuAllocations map[string][]int
+	// node each service is placed on, and what is reserved on each node
+	ServiceNodes  map[string]string
+	NodeAllocated map[string]Resources
 
 	// prevent concurrent docker api calls per container
 	ContainerAPILock *MutexMap
+	// launches in progress per service, and image pulls per host
+	LaunchFlights *FlightGroup
+	PullFlights   *FlightGroup
+	// one client per Docker host, shared by every call
+	DockerLock    sync.Mutex
+	DockerClients map[string]*client.Client
+	// cancelled once Start returns, abandoning Docker calls still in flight
+	ctx context.Context
+	// ends Start early, with the cause as its error
+	stop context.CancelCauseFunc
+
+	AccessLog *slog.Logger
+	AuditLog  *AuditLog
+	StatsD    *StatsD
+	State     *StateStore
+	Cluster   *Cluster
+	Gossip    *Gossip
+
+	// switch to this user once the proxy ports are bound
+	User string
+
+	// set by NewServer's options
+	Runtime         Runtime
+	Logger          *slog.Logger
+	ListenerFactory ListenerFactory
+
+	// one per proxied connection while maxConnections is set
+	ConnSlots chan struct{}
+
+	// proxy listeners currently accepting, out of every enabled host port
+	Listeners         atomic.Int32
+	ExpectedListeners atomic.Int32
+	// open listeners per service, and the services DisableService closed
+	ListenerLock     sync.Mutex
+	ServiceListeners map[string][]net.Listener
+	ServiceDisabled  map[string]bool
+
+	// naming templates, parsed once by ValidateNaming
+	naming containerNaming
 }
 
-func (s *Server) Start() (err error) {
-	// Listen on all configured ports
+// Start adopts running containers, opens the proxy ports, and serves until
+// ctx is cancelled. Containers are left as they are on the way out.
+func (s *Server) Start(ctx context.Context) (err error) {
+	ctx, stop := context.WithCancelCause(ctx)
+	defer stop(nil)
+	s.ctx = ctx
+	s.stop = stop
+	defer s.CloseDocker()
+	err = s.CheckDependencies()
+	if err != nil {
+		return
+	}
+	err = s.ValidateInstanceNames()
+	if err != nil {
+		return
+	}
+	err = s.ValidateMounts()
+	if err != nil {
+		return
+	}
+	err = s.ValidateRestartPolicies()
+	if err != nil {
+		return
+	}
+	err = s.ValidatePorts()
+	if err != nil {
+		return
+	}
+	err = s.ValidatePlaceholders()
+	if err != nil {
+		return
+	}
+	err = s.ValidateConnectionCap()
+	if err != nil {
+		return
+	}
+	if s.Config.Resources.AutoDetect {
+		err = s.DetectLimits()
+		if err != nil {
+			return
+		}
+	}
+	if s.Config.HA != nil {
+		// a standby waits here, then picks up where the old leader left off
+		err = s.AwaitLeadership(ctx)
+		if err != nil {
+			return
+		}
+	}
+	saved, err := s.State.Load()
+	if err != nil {
+		return
+	}
+	if s.Config.HA != nil {
+		var shared map[string]ServiceState
+		shared, err = s.Cluster.LoadState(ctx)
+		if err != nil {
+			return
+		}
+		for name, state := range shared {
+			saved[name] = state
+		}
+	}
+	err = s.MigrateContainerNames()
+	if err != nil {
+		return
+	}
+	err = s.AdoptContainers(saved)
+	if err != nil {
+		return
+	}
+	if s.Config.RemoveOrphans {
+		err = s.RemoveOrphans()
+		if err != nil {
+			return
+		}
+	}
+
+	// Listen on all configured ports, using sockets from systemd where given
+	activated, err := SystemdListeners()
+	if err != nil {
+		return
+	}
+	defer s.CloseAllListeners()
 	for _, app := range s.Config.Services {
 		for _, port := range app.Ports {
-			for _, hostPort := range port.HostPorts {
-				listener, err := net.Listen("tcp", s.Config.ProxyIP+":"+fmt.Sprint(hostPort))
-				if err != nil {
-					log.Println("Error listening on port", hostPort, "for application", app.Name, ":", err.Error())
-					return err
-				}
-				defer listener.Close()
-				log.Println("Listening on port", hostPort, "for application", app.Name)
-				go s.Listen(listener, app, port)
+			if port.Direct() {
+				s.Logger.Info("Publishing port from the container", "service", app.Name, "port", port.ContainerPort, "protocol", port.Transport(), "hostPorts", port.HostPorts)
 			}
 		}
+		err = s.OpenListeners(app, activated)
+		if err != nil {
+			return
+		}
+	}
+	for port, listener := range activated {
+		s.Logger.Warn("Closing socket from systemd that no service uses", "port", port)
+		listener.Close()
+	}
+	if s.User != "" {
+		err = DropPrivileges(s.User)
+		if err != nil {
+			return
+		}
+		s.Logger.Info("Dropped privileges", "user", s.User)
+	}
+	err = s.RunSchedules(ctx)
+	if err != nil {
+		return
+	}
+	go s.WatchDocker(ctx)
+	if s.Config.ReconcileInterval >= 0 {
+		go s.Reconcile(ctx)
+	}
+	go s.WatchImageUpdates(ctx)
+	go s.WatchHealth(ctx)
+	go s.CollectStats(ctx)
+	go s.WatchTraffic(ctx)
+	if s.Config.Ban != nil {
+		go s.SweepBans(ctx)
+	}
+	go s.RunAutoscalers(ctx)
+	go s.RunWarmPools(ctx)
+	if s.Config.Admin != nil && s.Config.Admin.Bind != "" {
+		go s.ServeAdmin(ctx)
+	}
+	if s.StatsD != nil {
+		go s.PushGauges(ctx)
+	}
+	if s.State != nil {
+		go s.PersistState(ctx)
+	}
+	if s.Cluster != nil {
+		go s.RunCluster(ctx)
+	}
+	if s.Gossip != nil {
+		go s.RunGossip(ctx)
+		if s.Gossip.config.Bind != "" {
+			go s.ServeGossip(ctx)
+		}
 	}
 	// blocking
-	s.CleanUpContainers()
+	s.CleanUpContainers(ctx)
+	if cause := context.Cause(ctx); errors.Is(cause, ErrSteppedDown) {
+		err = cause
+	}
 	return
 }
 
-func (s *Server) CleanUpContainers() {
-	for {
+func (s *Server) CleanUpContainers(ctx context.Context) {
+	for ctx.Err() == nil {
 		toKill := make([]string, 0)
 		func() {
 			s.ServerLock.RLock()
 			defer s.ServerLock.RUnlock()
-			for container, ts := range s.ServiceKillTime {
-				if time.Since(ts).Seconds() > 0 {
-					if count, ok := s.ServiceConnCount[container]; ok {
-						if count == 0 {
-							toKill = append(toKill, container)
-						}
-					} else {
-						log.Println("Container", container, "was scheduled to die, but connection ref count is nil.")
+			now := s.Clock.Now()
+			for container, ts := range s.KillTimes() {
+				if now.Before(s.ServiceWarmUntil[container]) {
+					continue
+				}
+				if app := s.FindService(container); app != nil {
+					// give a fresh container time to pay off its cold start
+					if now.Sub(s.ServiceStartTime[container]) < time.Duration(app.MinUptime)*time.Second {
+						continue
 					}
+					if s.GroupConnCount(*app) > 0 {
+						continue
+					}
+					if s.CpuBusy(*app) {
+						continue
+					}
+					if s.RemoteBusy(*app, now) || s.Gossip.PeerBusy(app.Name) {
+						continue
+					}
+				}
+				if now.After(ts) && s.ConnCount(container) == 0 {
+					toKill = append(toKill, container)
 				}
 			}
 		}()
+		s.RecycleContainers()
 		for _, container := range toKill {
-			log.Println("Stopping container", container)
-			err := s.StopContainer(container)
+			if app := s.FindService(container); app != nil && s.ProbeBusy(*app) {
+				s.Logger.Info("Idle probe says the service is in use, deferring stop", "service", container)
+				s.PostponeKillTime(container, s.Clock.Now().Add(time.Duration(app.CoolDown)*time.Second))
+				continue
+			}
+			unlock, ok := s.Cluster.TryLock(ctx, container)
+			if !ok {
+				continue
+			}
+			// what the other nodes said may be a second old
+			if app := s.FindService(container); app != nil && s.RemoteBusyNow(*app) {
+				unlock()
+				continue
+			}
+			s.Logger.Info("Stopping container", "service", container)
+			var err error
+			reason := "idle"
+			if s.TakeRecreatePending(container) {
+				s.Logger.Info("Removing container to pick up its updated image", "service", container)
+				reason = "idle with updated image"
+				err = s.StopContainerWithAction(container, IdleRemove, false)
+			} else {
+				err = s.StopContainer(container)
+			}
+			unlock()
 			if err != nil {
-				log.Println("Error stopping container", container, ":", err.Error())
+				s.Logger.Error("Error stopping container", "service", container, "err", err)
+			} else {
+				s.AuditLog.Record(AuditEntry{Action: AuditStop, Service: container, Reason: reason})
 			}
-			func() {
-				s.ServerLock.Lock()
-				defer s.ServerLock.Unlock()
-				delete(s.ServiceKillTime, container)
-			}()
+			s.ClearKillTime(container)
 		}
-		time.Sleep(1 * time.Second)
+		s.waitToReap(ctx)
 	}
 }
 
-func (s *Server) FindOpenPort(ip string) (int, error) {
-	rangeStart := 49152
-	rangeEnd := 65535
-
-	attemptPort := rangeStart + rand.Intn(rangeEnd-rangeStart)
-	maxAttempts := 32
-	for i := 0; i < maxAttempts; i++ {
-		listener, err := net.Listen("tcp", ip+":"+fmt.Sprint(attemptPort))
-		if err != nil {
-			// port is in use, try next port
-			attemptPort = (attemptPort + 1)
-			if attemptPort > rangeEnd {
-				attemptPort = rangeStart
+// RecycleContainers removes containers that outlived their maxLifetime as
+// soon as they are drained, so the next connection creates a fresh one.
+func (s *Server) RecycleContainers() {
+	toRecycle := make([]string, 0)
+	func() {
+		s.ServerLock.RLock()
+		defer s.ServerLock.RUnlock()
+		for _, app := range s.Instances() {
+			if app.MaxLifetime <= 0 {
+				continue
 			}
-		} else {
-			listener.Close()
-			return attemptPort, nil
+			startTime, ok := s.ServiceStartTime[app.Name]
+			if !ok || s.Clock.Now().Sub(startTime) < time.Duration(app.MaxLifetime)*time.Second {
+				continue
+			}
+			if s.ConnCount(app.Name) > 0 {
+				continue
+			}
+			toRecycle = append(toRecycle, app.Name)
+		}
+	}()
+	for _, name := range toRecycle {
+		unlock, ok := s.Cluster.TryLock(s.ctx, name)
+		if !ok {
+			continue
+		}
+		s.Logger.Info("Recycling container after exceeding its max lifetime", "service", name)
+		err := s.StopContainerWithAction(name, IdleRemove, false)
+		unlock()
+		if err != nil {
+			s.Logger.Error("Error recycling container", "service", name, "err", err)
+			continue
+		}
+		s.AuditLog.Record(AuditEntry{Action: AuditStop, Service: name, Reason: "max lifetime"})
+		s.ClearKillTime(name)
+	}
+}
+
+func (s *Server) FindService(name string) *Service {
+	for i := range s.Config.Services {
+		if s.Config.Services[i].Name == name {
+			return &s.Config.Services[i]
+		}
+	}
+	if successor := s.findSuccessor(name); successor != nil {
+		return successor
+	}
+	if member := s.findPoolMember(name); member != nil {
+		return member
+	}
+	return s.findReplica(name)
+}
+
+// ForgetPortMappings drops a removed container's host ports so the next container gets fresh ones.
+func (s *Server) ForgetPortMappings(name string) {
+	s.ServerLock.Lock()
+	defer s.ServerLock.Unlock()
+	delete(s.ServiceProxyHostPortMap, name)
+	delete(s.ServicePortsContainer, name)
+}
+
+// RefreshPortMappings reads app's backend ports again after a dial failed,
+// in case its container was recreated or restarted behind our back. It
+// reports whether the ports changed.
+func (s *Server) RefreshPortMappings(app Service) (changed bool, err error) {
+	s.ContainerAPILock.Lock(app.Name)
+	defer s.ContainerAPILock.Unlock(app.Name)
+	cli, err := s.Docker(app.Name)
+	if err != nil {
+		return
+	}
+	var cont *types.Container
+	cont, err = s.FindContainer(cli, app.Name)
+	if err != nil {
+		return
+	}
+	before := make(map[int]int)
+	func() {
+		s.ServerLock.RLock()
+		defer s.ServerLock.RUnlock()
+		for containerPort, hostPort := range s.ServiceProxyHostPortMap[app.Name] {
+			before[containerPort] = hostPort
 		}
+	}()
+	if cont == nil || cont.State != "running" {
+		s.ForgetPortMappings(app.Name)
+		return
 	}
-	return -1, fmt.Errorf("could not find open port after %d attempts", maxAttempts)
+	err = s.LoadPortMappings(cli, app, cont.ID)
+	if err != nil {
+		return
+	}
+	s.ServerLock.RLock()
+	defer s.ServerLock.RUnlock()
+	return !maps.Equal(before, s.ServiceProxyHostPortMap[app.Name]), nil
 }
 
 func (s *Server) Listen(listener net.Listener, app Service, port PortMapping) {
+	s.Listeners.Add(1)
+	defer s.Listeners.Add(-1)
+	if strings.ToLower(port.Protocol) == ProtocolHTTP {
+		err := s.ServeHTTPPort(listener, app, port)
+		if errors.Is(err, net.ErrClosed) {
+			s.Logger.Info("Listener closed", "service", app.Name, "addr", listener.Addr().String())
+		} else {
+			s.Logger.Error("Listener failed", "service", app.Name, "addr", listener.Addr().String(), "err", err)
+		}
+		return
+	}
+	var delay time.Duration
 	for {
 		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			s.Logger.Info("Listener closed", "service", app.Name, "addr", listener.Addr().String())
+			return
+		}
 		if err != nil {
-			log.Println("Error accepting connection: ", err.Error())
+			// e.g. out of file descriptors, which won't clear up by spinning
+			delay = min(max(2*delay, 5*time.Millisecond), time.Second)
+			s.Logger.Error("Error accepting connection", "service", app.Name, "err", err, "retry", delay)
+			time.Sleep(delay)
 			continue
 		}
-		log.Println("Accepted connection for application", app.Name, "on port", port.ContainerPort, "from", conn.RemoteAddr())
+		delay = 0
+		s.Logger.Debug("Accepted connection", "service", app.Name, "port", port.ContainerPort, "remote", conn.RemoteAddr().String())
 		go s.HandleConnection(conn, app, port)
 	}
 }
@@ -173,120 +785,362 @@ func (s *Server) Listen(listener net.Listener, app Service, port PortMapping) {
 func (s *Server) HandleConnection(src net.Conn, app Service, port PortMapping) {
 	defer src.Close()
 
-	containerActive := false
+	app = s.Successor(s.PickReplica(app, src.RemoteAddr()))
+	logger := s.Logger.With("service", app.Name, "port", port.ContainerPort, "remote", src.RemoteAddr().String())
+	ctx, span := tracer.Start(WithTrigger(s.ctx, "connection", src.RemoteAddr().String()), "connection", trace.WithAttributes(
+		attribute.String("service", app.Name),
+		attribute.Int("port", port.ContainerPort),
+		attribute.String("remote", src.RemoteAddr().String()),
+	))
+	defer span.End()
+	entry := access{began: time.Now()}
+	defer func() { s.LogAccess(app, src.RemoteAddr().String(), entry) }()
+	containerActive := s.ConnCount(app.Name) > 0
+	running := false
 	func() {
 		s.ServerLock.RLock()
 		defer s.ServerLock.RUnlock()
-		if count, ok := s.ServiceConnCount[app.Name]; ok {
-			containerActive = count > 0
-		}
+		_, running = s.ServiceStartTime[app.Name]
 	}()
-	if !containerActive {
-		err := s.LaunchContainer(app)
+	// a peer already running it saves launching a duplicate
+	peer := ""
+	if !running {
+		peer = s.Gossip.PeerBackend(app.Name, port.ContainerPort)
+	}
+	span.SetAttributes(attribute.Bool("cold", !containerActive && peer == ""))
+	entry.cold = !containerActive && peer == ""
+	s.StatsD.Count("connections", 1, "service:"+app.Name, fmt.Sprintf("cold:%t", entry.cold))
+	if entry.cold && s.Banned(src.RemoteAddr().String()) {
+		logger.Debug("Refusing to wake for banned source")
+		entry.cause = "banned"
+		return
+	}
+	if entry.cold {
+		wakeCtx, wakeSpan := tracer.Start(ctx, "wake")
+		err := s.WaitForStartup(wakeCtx, app)
+		endSpan(wakeSpan, err)
 		if err != nil {
-			log.Println("Error launching container: ", err.Error())
+			logger.Error("Error launching container", "err", err)
+			span.SetStatus(codes.Error, err.Error())
+			entry.cause = "launch: " + err.Error()
 			return
 		}
 	}
 
-	// refcount
-	func() {
-		s.ServerLock.Lock()
-		defer s.ServerLock.Unlock()
-		s.ServiceConnCount[app.Name]++
-	}()
-	// on closed, give the container a deadline
-	defer func() {
-		s.ServerLock.Lock()
-		defer s.ServerLock.Unlock()
-		s.ServiceConnCount[app.Name]--
-		if count, ok := s.ServiceConnCount[app.Name]; ok {
-			if count == 0 {
-				s.ServiceKillTime[app.Name] = time.Now().Add(time.Duration(app.CoolDown) * time.Second)
-			}
-		}
-	}()
+	if peer != "" {
+		s.Gossip.Use(app.Name)
+		defer s.Gossip.Done(app.Name)
+		entry.backend = peer
+	} else {
+		// on closed, give the container a deadline
+		defer s.Acquire(app, port)()
+		entry.backend = s.Backend(app, port)
+	}
 
 	// connect to container
+	_, dialSpan := tracer.Start(ctx, "dial backend")
+	dest, err := s.DialBackend(ctx, app, entry.backend)
+	if err != nil && peer == "" {
+		if changed, _ := s.RefreshPortMappings(app); changed {
+			logger.Warn("Backend port changed, redialing", "err", err)
+			entry.backend = s.Backend(app, port)
+			dest, err = s.DialBackend(ctx, app, entry.backend)
+		}
+	}
+	endSpan(dialSpan, err)
+	if err != nil {
+		logger.Error("Error connecting to destination", "err", err)
+		entry.cause = "dial: " + err.Error()
+		return
+	}
+	defer dest.Close()
+	tuning := proxyTuning(app, port)
+	tuning.Apply(src)
+	tuning.Apply(dest)
+	upstream, downstream := tuning.BufferSizes()
+
+	waitGroup := sync.WaitGroup{}
+	waitGroup.Add(2)
+	var inErr, outErr error
+	copy := func(s io.Reader, d io.Writer, size int, n *int64, err *error) {
+		*n, *err = copyBuffered(d, s, size)
+		if *err != nil {
+			logger.Warn("Error copying from source to destination", "err", *err)
+		}
+		waitGroup.Done()
+	}
+	// copy on the socket itself, the deferred Close still frees its slot
+	conn := src
+	if slot, ok := src.(*slotConn); ok {
+		conn = slot.Conn
+	}
+	var srcWriter, destWriter io.Writer = conn, dest
+	if strings.ToLower(app.IdleMode) == IdleTraffic {
+		clock := s.TrafficClock(app.Name)
+		clock.Store(s.Clock.Now().UnixNano())
+		srcWriter = trafficWriter{src, clock, s.Clock}
+		destWriter = trafficWriter{dest, clock, s.Clock}
+	}
+	_, streamSpan := tracer.Start(ctx, "stream")
+	go copy(conn, destWriter, upstream, &entry.bytesIn, &inErr)
+	go copy(dest, srcWriter, downstream, &entry.bytesOut, &outErr)
+	waitGroup.Wait()
+	streamSpan.SetAttributes(attribute.Int64("bytesIn", entry.bytesIn), attribute.Int64("bytesOut", entry.bytesOut))
+	streamSpan.End()
+	if inErr != nil {
+		entry.cause = "copy: " + inErr.Error()
+	} else if outErr != nil {
+		entry.cause = "copy: " + outErr.Error()
+	}
+	if entry.bytesIn == 0 {
+		s.Strike(app, src.RemoteAddr().String())
+	}
+	logger.Debug("Closed connection")
+}
+
+// schedulePortKill schedules the group's shutdown, using port's cooldown
+// override for app itself.
+func (s *Server) schedulePortKill(app Service, port PortMapping) {
+	s.ScheduleGroupKill(app)
+	if port.CoolDown != nil {
+		s.PostponeKillTime(app.Name, s.Clock.Now().Add(time.Duration(*port.CoolDown)*time.Second))
+	}
+}
+
+// Backend is the host address a service's container port is published on.
+func (s *Server) Backend(app Service, port PortMapping) string {
 	hostIP := s.Config.ServiceHostIP
 	if app.HostIP != "" {
 		hostIP = app.HostIP
 	}
-	var backendHostPort int
-	func() {
-		backendHostPort = -1
-		s.ServerLock.RLock()
-		defer s.ServerLock.RUnlock()
-		if m, ok := s.ServiceProxyHostPortMap[app.Name]; ok {
-			if p, ok := m[port.ContainerPort]; ok {
-				backendHostPort = p
-			}
+	if node := s.NodeOf(app.Name); node != nil {
+		hostIP = node.IP
+	}
+	backendHostPort := -1
+	s.ServerLock.RLock()
+	defer s.ServerLock.RUnlock()
+	if m, ok := s.ServiceProxyHostPortMap[app.Name]; ok {
+		if p, ok := m[port.ContainerPort]; ok {
+			backendHostPort = p
 		}
+	}
+	return hostIP + ":" + fmt.Sprint(backendHostPort)
+}
+
+// RemoveContainer force removes a container and lets go of everything it
+// held. The caller must hold the service's ContainerAPILock.
+func (s *Server) RemoveContainer(cli *client.Client, app Service, cont *types.Container) (err error) {
+	ctx, cancel := s.dockerContext(s.ctx)
+	defer cancel()
+	err = cli.ContainerRemove(ctx, cont.ID, types.ContainerRemoveOptions{Force: true})
+	if err != nil {
+		return
+	}
+	if cont.State == "running" || cont.State == "paused" {
+		// it was still holding a reservation
+		s.ReleaseResources(app)
+		if cont.State == "running" {
+			s.ReleaseDependencies(app)
+		}
+	}
+	s.ForgetPortMappings(app.Name)
+	func() {
+		s.ServerLock.Lock()
+		defer s.ServerLock.Unlock()
+		delete(s.ServiceStartTime, app.Name)
 	}()
-	dest, err := net.DialTimeout("tcp", hostIP+":"+fmt.Sprint(backendHostPort), 10*time.Second)
+	return
+}
+
+// LoadPortMappings reads a container's backend host ports back from Docker.
+func (s *Server) LoadPortMappings(cli *client.Client, app Service, contID string) (err error) {
+	ctx, cancel := s.dockerContext(s.ctx)
+	defer cancel()
+	var inspect types.ContainerJSON
+	inspect, err = cli.ContainerInspect(ctx, contID)
 	if err != nil {
-		log.Println("Error connecting to destination: ", err.Error())
+		s.Logger.Error("Error inspecting container", "service", app.Name, "err", err)
 		return
 	}
-	defer dest.Close()
 
-	waitGroup := sync.WaitGroup{}
-	waitGroup.Add(2)
-	copy := func(s io.Reader, d io.Writer) {
-		_, err = io.Copy(d, s)
+	s.ServerLock.Lock()
+	defer s.ServerLock.Unlock()
+
+	if _, ok := s.ServiceProxyHostPortMap[app.Name]; !ok || s.ServicePortsContainer[app.Name] != inspect.ID {
+		// anything from an earlier container is stale
+		s.ServiceProxyHostPortMap[app.Name] = make(map[int]int)
+	}
+	s.ServicePortsContainer[app.Name] = inspect.ID
+	for natport, bindings := range inspect.HostConfig.PortBindings {
+		if natport.Proto() != ProtocolTCP {
+			// published directly, there is no backend to proxy to
+			continue
+		}
+		var containerPort int
+		containerPort, err = strconv.Atoi(strings.Split(string(natport), "/")[0])
 		if err != nil {
-			log.Println("Error copying from source to destination: ", err.Error())
+			s.Logger.Error("Error parsing port", "service", app.Name, "err", err)
+			return
 		}
-		waitGroup.Done()
+		hostPort := bindings[0].HostPort
+		if hostPort == "" {
+			// docker picked one when the container started
+			published := inspect.NetworkSettings.Ports[natport]
+			if len(published) == 0 {
+				continue
+			}
+			hostPort = published[0].HostPort
+		}
+		var backendHostPort int
+		backendHostPort, err = strconv.Atoi(hostPort)
+		if err != nil {
+			s.Logger.Error("Error parsing port", "service", app.Name, "err", err)
+			return
+		}
+		s.ServiceProxyHostPortMap[app.Name][containerPort] = backendHostPort
 	}
-	go copy(src, dest)
-	go copy(dest, src)
-	waitGroup.Wait()
-	log.Println("Closed connection for application", app.Name, "on port", port.ContainerPort, "from", src.RemoteAddr())
+	return
 }
 
-func (s *Server) LaunchContainer(app Service) (err error) {
+// findContainerNamed looks a container up by its exact name, returning nil if
+// there is none.
+func findContainerNamed(ctx context.Context, cli *client.Client, containerName string) (cont *types.Container, err error) {
+	inspect, err := cli.ContainerInspect(ctx, containerName)
+	if client.IsErrNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return
+	}
+	// Docker falls back to matching ID prefixes, which a short name could
+	// happen to be
+	if inspect.Name != "/"+containerName {
+		return
+	}
+	cont = &types.Container{
+		ID:      inspect.ID,
+		Names:   []string{inspect.Name},
+		ImageID: inspect.Image,
+	}
+	if inspect.Config != nil {
+		cont.Image = inspect.Config.Image
+		cont.Labels = inspect.Config.Labels
+	}
+	if inspect.State != nil {
+		cont.State = inspect.State.Status
+	}
+	return
+}
+
+// LaunchContainer starts app's container. Callers that ask while a launch of
+// the same service is already underway share its result rather than queueing
+// up to repeat it.
+func (s *Server) LaunchContainer(ctx context.Context, app Service) (err error) {
+	return s.LaunchFlights.Do(ctx, s.ctx, app.Name, func(ctx context.Context) error {
+		return s.launchContainer(ctx, app)
+	})
+}
+
+func (s *Server) launchContainer(ctx context.Context, app Service) (err error) {
+	logger := s.Logger.With("service", app.Name)
+	began := time.Now()
+	var phases ColdStart
+	err = s.LaunchDependencies(ctx, app)
+	if err != nil {
+		logger.Error("Error launching dependencies", "err", err)
+		return
+	}
+
+	// another node may be launching or stopping it
+	unlock, err := s.Cluster.Lock(ctx, app.Name)
+	if err != nil {
+		logger.Error("Error locking service in cluster", "err", err)
+		return
+	}
+	defer unlock()
+
 	s.ContainerAPILock.Lock(app.Name)
 	defer s.ContainerAPILock.Unlock(app.Name)
 
-	cli, err := client.NewClientWithOpts(client.FromEnv)
+	err = s.PlaceService(app)
 	if err != nil {
-		panic(err)
+		logger.Error("Error placing service", "err", err)
+		return
+	}
+	cli, err := s.Docker(app.Name)
+	if err != nil {
+		logger.Error("Error connecting to Docker", "err", err)
+		return
 	}
-	defer cli.Close()
+	node := s.NodeOf(app.Name)
 
-	containerName := app.Name + "-goscalezero"
+	containerName := s.ContainerName(app.Name)
 
 	// Check if the container exists
 	var cont *types.Container
-
-	var list []types.Container
-	list, err = cli.ContainerList(context.Background(), types.ContainerListOptions{
-		All:     true,
-		Filters: filters.NewArgs(filters.KeyValuePair{Key: "name", Value: "/" + containerName}),
-	})
+	cont, err = s.FindContainer(cli, app.Name)
 	if err != nil {
-		log.Println("Error listing containers: ", err.Error())
+		logger.Error("Error listing containers", "err", err)
 		return
 	}
-searchlist:
-	for _, listcont := range list { // this seems expensive
-		for _, name := range listcont.Names {
-			if name == "/"+containerName {
-				cont = &listcont
-				break searchlist
+	if cont != nil {
+		var moved bool
+		moved, err = s.Migrate(cli, app, cont)
+		if err != nil {
+			logger.Error("Error migrating container", "err", err)
+			return
+		}
+		if moved {
+			cli, err = s.Docker(app.Name)
+			if err != nil {
+				return
 			}
+			node = s.NodeOf(app.Name)
+			cont = nil
 		}
 	}
+	if cont == nil {
+		cont, err = s.TakePooled(cli, app)
+		if err != nil {
+			logger.Error("Error taking pooled container", "err", err)
+			return
+		}
+	}
+
+	config, hostConfig, err := s.ContainerSpec(app)
+	if err != nil {
+		return
+	}
+	specHash, err := SpecHash(app, &config, &hostConfig)
+	if err != nil {
+		logger.Error("Error hashing container spec", "err", err)
+		return
+	}
 
 	// Check if container is valid
-	if cont != nil && cont.Image != app.Image {
-		log.Println("Container image does not match")
+	imageMatches := true
+	specMatches := true
+	if cont != nil {
+		matchCtx, cancel := s.dockerContext(ctx)
+		imageMatches, err = ContainerImageMatches(matchCtx, cli, cont, app.Image)
+		cancel()
+		if err != nil {
+			logger.Error("Error inspecting image", "err", err)
+			return
+		}
+		specMatches = cont.Labels[SpecHashLabel] == specHash
+	}
+	if cont != nil && (!imageMatches || !specMatches) {
+		if !imageMatches {
+			logger.Info("Container image does not match")
+		} else {
+			logger.Info("Container config does not match")
+		}
 
 		// Remove the container
-		err = cli.ContainerRemove(context.Background(), cont.ID, types.ContainerRemoveOptions{Force: true})
+		err = s.RemoveContainer(cli, app, cont)
 		if err != nil {
-			log.Println("Error removing container: ", err.Error())
+			logger.Error("Error removing container", "err", err)
 			return
 		}
 
@@ -300,354 +1154,422 @@ searchlist:
 		if err != nil {
 			return
 		}
-		// Store port mappings (if not already stored)
-		needPortMappings := false
-		func() {
-			s.ServerLock.RLock()
-			defer s.ServerLock.RUnlock()
-			if _, ok := s.ServiceProxyHostPortMap[app.Name]; !ok {
-				needPortMappings = true
-			}
-		}()
-		if needPortMappings {
-			err = func() (err error) {
-				var inspect types.ContainerJSON
-				inspect, err = cli.ContainerInspect(context.Background(), contID)
-				if err != nil {
-					log.Println("Error inspecting container: ", err.Error())
-					return
-				}
-
-				s.ServerLock.Lock()
-				defer s.ServerLock.Unlock()
-
-				if _, ok := s.ServiceProxyHostPortMap[app.Name]; !ok {
-					s.ServiceProxyHostPortMap[app.Name] = make(map[int]int)
-				}
-				for natport, bindings := range inspect.HostConfig.PortBindings {
-					var containerPort int
-					containerPort, err = strconv.Atoi(strings.Split(string(natport), "/")[0])
-					if err != nil {
-						log.Println("Error parsing port: ", err.Error())
-						return
-					}
-					var backendHostPort int
-					backendHostPort, err = strconv.Atoi(bindings[0].HostPort)
-					if err != nil {
-						log.Println("Error parsing port: ", err.Error())
-						return
-					}
-					s.ServiceProxyHostPortMap[app.Name][containerPort] = backendHostPort
-				}
-				return
-			}()
-			if err != nil {
-				return
-			}
+		// docker picks the backend ports on every start
+		err = s.LoadPortMappings(cli, app, contID)
+		if err != nil {
+			return
 		}
 	}()
 
 	if cont == nil {
-		log.Println("Container does not exist")
+		logger.Debug("Container does not exist")
 
 		// Pull the image
+		pullBegan := time.Now()
 		switch strings.ToLower(app.PullPolicy) {
 		case Always:
-			log.Println("Pulling image with pull policy Always. This is not recommended. Consider using IfNotPresent.")
+			logger.Warn("Pulling image with pull policy Always. This is not recommended. Consider using IfNotPresent.")
 			func() {
-				var resp io.ReadCloser
-				resp, err = cli.ImagePull(context.Background(), app.Image, types.ImagePullOptions{})
+				_, span := tracer.Start(ctx, "pull image", trace.WithAttributes(attribute.String("image", app.Image)))
+				defer func() { endSpan(span, err) }()
+				err = s.PullImage(ctx, cli, app.Image)
 				if err != nil {
-					log.Println("Error pulling image: ", err.Error())
+					logger.Error("Error pulling image", "err", err)
 					return // continue with old image
 				}
-				io.Copy(os.Stdout, resp)
 			}()
 		case IfNotPresent:
 			// check if image exists
 			func() {
+				listCtx, cancel := s.dockerContext(ctx)
+				defer cancel()
 				var images []types.ImageSummary
-				images, err = cli.ImageList(context.Background(), types.ImageListOptions{})
+				images, err = cli.ImageList(listCtx, types.ImageListOptions{})
 				if err != nil {
-					log.Println("Error listing images: ", err.Error())
+					logger.Error("Error listing images", "err", err)
 					return // continue with old image
 				}
 				for _, image := range images {
-					for _, tag := range image.RepoTags {
-						if tag == app.Image {
-							log.Println("Existing image found for", app.Image)
-							return // continue with old image
-						}
+					if ImageSummaryMatches(image, app.Image) {
+						logger.Debug("Existing image found", "image", app.Image)
+						return // continue with old image
 					}
 				}
-				var resp io.ReadCloser
-				resp, err = cli.ImagePull(context.Background(), app.Image, types.ImagePullOptions{})
+				_, span := tracer.Start(ctx, "pull image", trace.WithAttributes(attribute.String("image", app.Image)))
+				defer func() { endSpan(span, err) }()
+				err = s.PullImage(ctx, cli, app.Image)
 				if err != nil {
-					log.Println("Error pulling image: ", err.Error())
+					logger.Error("Error pulling image", "err", err)
 					return // will fail because no image
 				}
-				io.Copy(os.Stdout, resp)
 			}()
 		case Never, None: // do nothing
 		default:
-			log.Println("Unknown pull policy: ", app.PullPolicy)
+			logger.Warn("Unknown pull policy", "policy", app.PullPolicy)
 		}
+		phases.PullMs = time.Since(pullBegan).Milliseconds()
 
 		// Create the container
 		hostIP := s.Config.ServiceHostIP
+		if node != nil {
+			hostIP = ""
+		}
 		if app.HostIP != "" {
 			hostIP = app.HostIP
 		}
 		portMap := nat.PortMap{}
 		for _, port := range app.Ports {
 			var containerPort nat.Port
-			containerPort, err = nat.NewPort("tcp", fmt.Sprint(port.ContainerPort))
+			containerPort, err = nat.NewPort(port.Transport(), fmt.Sprint(port.ContainerPort))
 			if err != nil {
-				log.Println("Port not available: ", err.Error())
+				logger.Error("Port not available", "err", err)
 				return
 			}
-			portBindings := make([]nat.PortBinding, 1)
-
-			// Find open host ports to bind the container to
-			var backendHostPort int
-			err = func() (err error) {
-				s.ServerLock.Lock()
-				defer s.ServerLock.Unlock()
-
-				if _, ok := s.ServiceProxyHostPortMap[app.Name]; !ok {
-					s.ServiceProxyHostPortMap[app.Name] = make(map[int]int)
+			if port.Direct() {
+				bindIP := s.Config.ProxyIP
+				if node != nil {
+					bindIP = ""
 				}
-				s.ServiceProxyHostPortMap[app.Name][port.ContainerPort], err = s.FindOpenPort(hostIP)
-				if err != nil {
-					log.Println("Error finding open port: ", err.Error())
-					return
+				for _, hostPort := range port.HostPorts {
+					portMap[containerPort] = append(portMap[containerPort], nat.PortBinding{HostIP: bindIP, HostPort: fmt.Sprint(hostPort)})
 				}
-				log.Println("Found open port", s.ServiceProxyHostPortMap[app.Name][port.ContainerPort], "for application", app.Name, "on host", hostIP, "for container port", port.ContainerPort, "on proxy", s.Config.ProxyIP)
-				backendHostPort = s.ServiceProxyHostPortMap[app.Name][port.ContainerPort]
-				return
-			}()
-			if err != nil {
-				return
+				continue
 			}
-
-			portBindings[0] = nat.PortBinding{
-				HostIP:   hostIP,
-				HostPort: fmt.Sprint(backendHostPort),
+			// docker binds a free port as the container starts, so nothing
+			// can take it in between. It is read back once started.
+			binding := nat.PortBinding{HostIP: hostIP}
+			if port.Transparent {
+				binding.HostPort = fmt.Sprint(port.ContainerPort)
 			}
-			portMap[containerPort] = portBindings
+			portMap[containerPort] = []nat.PortBinding{binding}
 		}
 
-		resources := container.Resources{}
-		if app.ResourceRequest.MemoryMi > 0 {
-			resources.Memory = int64(app.ResourceRequest.MemoryMi * 1024 * 1024)
+		config.Labels[SpecHashLabel] = specHash
+		for k, v := range ownershipLabels(app.Name) {
+			config.Labels[k] = v
 		}
-		if app.ResourceRequest.MilliCPU > 0 {
-			resources.NanoCPUs = int64(app.ResourceRequest.MilliCPU * 1000000)
+		hostConfig.PortBindings = portMap
+		if ids := s.AssignGpus(app); ids != nil {
+			logger.Info("Assigned GPUs", "gpus", ids)
+			hostConfig.Resources.DeviceRequests[0].Count = 0
+			hostConfig.Resources.DeviceRequests[0].DeviceIDs = ids
+			defer func() {
+				if err != nil {
+					s.ReleaseGpus(app)
+				}
+			}()
 		}
-		if app.ResourceRequest.GpuMemoryMi > 0 {
-			resources.DeviceRequests = []container.DeviceRequest{
-				{
-					Driver: "",
-					Count:  -1,
-					Capabilities: [][]string{
-						{"gpu"},
-					},
-				},
-			}
+		var cpus string
+		cpus, err = s.AssignCpus(app)
+		if err != nil {
+			logger.Error("Error pinning cpus", "err", err)
+			return
 		}
-		oomKillDisable := true
-		resources.OomKillDisable = &oomKillDisable
-
-		var config container.Config
-		if app.Config != nil {
-			configCopy := *app.Config
-			config = configCopy
-		} else {
-			config = container.Config{}
+		if cpus != "" {
+			logger.Info("Pinned cpus", "cpus", cpus)
+			hostConfig.Resources.CpusetCpus = cpus
+			defer func() {
+				if err != nil {
+					s.ReleaseCpus(app)
+				}
+			}()
 		}
-		config.Image = app.Image
-		config.Cmd = app.Cmd
 
-		var hostConfig container.HostConfig
-		if app.HostConfig != nil {
-			hostConfigCopy := *app.HostConfig
-			hostConfig = hostConfigCopy
-		} else {
-			hostConfig = container.HostConfig{}
+		err = s.EnsureNetworks(ctx, cli, app)
+		if err != nil {
+			logger.Error("Error creating networks", "err", err)
+			return
+		}
+		err = s.PrepareMounts(app)
+		if err != nil {
+			logger.Error("Error preparing mounts", "err", err)
+			return
 		}
-		hostConfig.NetworkMode = container.NetworkMode("default")
-		hostConfig.PortBindings = portMap
-		hostConfig.Resources = resources
 
 		var resp container.CreateResponse
+		createBegan := time.Now()
+		createCtx, cancel := s.dockerContext(ctx)
 		resp, err = cli.ContainerCreate(
-			context.Background(),
+			createCtx,
 			&config,
 			&hostConfig,
-			nil,
+			NetworkingConfig(app),
 			nil,
 			containerName,
 		)
+		cancel()
 		if err != nil {
-			log.Println("Error creating container: ", err.Error())
+			logger.Error("Error creating container", "err", err)
 			return
 		}
+		err = s.ConnectNetworks(ctx, cli, app, resp.ID)
+		if err != nil {
+			logger.Error("Error attaching networks", "err", err)
+			removeCtx, cancel := s.dockerContext(s.ctx)
+			cli.ContainerRemove(removeCtx, resp.ID, types.ContainerRemoveOptions{Force: true})
+			cancel()
+			return
+		}
+		phases.CreateMs = time.Since(createBegan).Milliseconds()
 		contID = resp.ID
 	} else {
 		contID = cont.ID
 		// Check if the container is already running
 		if cont.State == "running" {
-			log.Println("Container", cont.ID, "is already running")
+			logger.Debug("Container is already running", "container", cont.ID)
+			return
+		} else if cont.State == "paused" {
+			// resources stay reserved while paused
+			unpauseCtx, cancel := s.dockerContext(ctx)
+			err = cli.ContainerUnpause(unpauseCtx, cont.ID)
+			cancel()
+			if err != nil {
+				logger.Error("Error unpausing container", "err", err)
+				return
+			}
+			logger.Info("Unpaused container", "container", cont.ID)
+			s.AcquireDependencies(app)
 			return
 		} else {
-			log.Println("Container is not running (state:" + cont.State + ")")
+			logger.Debug("Container is not running", "state", cont.State)
 		}
 	}
 
-	err = func() error {
-		s.TrackedResourcesLock.RLock()
-		defer s.TrackedResourcesLock.RUnlock()
-		if s.TrackedResources.MilliCPU+app.ResourceRequest.MilliCPU > s.Config.Resources.Limits.MilliCPU {
-			return fmt.Errorf("not enough cpu resources to launch container")
-		}
-		if s.TrackedResources.MemoryMi+app.ResourceRequest.MemoryMi > s.Config.Resources.Limits.MemoryMi {
-			return fmt.Errorf("not enough memory resources to launch container")
-		}
-		if s.TrackedResources.GpuMemoryMi+app.ResourceRequest.GpuMemoryMi > s.Config.Resources.Limits.GpuMemoryMi {
-			return fmt.Errorf("not enough video memory resources to launch container")
-		}
-		return nil
-	}()
+	// reserve resources
+	err = s.AwaitResources(ctx, app)
 	if err != nil {
 		return
 	}
-
-	func() {
-		// reserve resources
-		s.TrackedResourcesLock.Lock()
-		defer s.TrackedResourcesLock.Unlock()
-		s.TrackedResources.MilliCPU += app.ResourceRequest.MilliCPU
-		s.TrackedResources.MemoryMi += app.ResourceRequest.MemoryMi
-		s.TrackedResources.GpuMemoryMi += app.ResourceRequest.GpuMemoryMi
-	}()
 	defer func() {
 		if err != nil {
 			// release unused resources
-			s.TrackedResourcesLock.Lock()
-			defer s.TrackedResourcesLock.Unlock()
-			s.TrackedResources.MilliCPU -= app.ResourceRequest.MilliCPU
-			s.TrackedResources.MemoryMi -= app.ResourceRequest.MemoryMi
-			s.TrackedResources.GpuMemoryMi -= app.ResourceRequest.GpuMemoryMi
+			s.ReleaseResources(app)
 		}
 	}()
 
-	// Start the container
-	err = cli.ContainerStart(context.Background(), contID, types.ContainerStartOptions{})
+	err = s.RunHooks(cli, app, PreStart, contID)
 	if err != nil {
-		log.Println("Error starting container: ", err.Error())
 		return
 	}
+	if cont != nil {
+		// it was turned off when the container was last stopped
+		s.setRestartPolicy(cli, app, contID, RestartPolicy(app))
+	}
+
+	// Start the container, restoring the idle checkpoint if there is one
+	startOptions := types.ContainerStartOptions{}
+	if cont != nil && strings.ToLower(app.IdleAction) == IdleCheckpoint {
+		startOptions.CheckpointID = idleCheckpointID
+	}
+	_, span := tracer.Start(ctx, "start container")
+	startBegan := time.Now()
+	startCtx, cancel := s.dockerContext(ctx)
+	err = cli.ContainerStart(startCtx, contID, startOptions)
+	cancel()
+	if err != nil && startOptions.CheckpointID != "" {
+		logger.Warn("Error restoring checkpoint, starting fresh", "err", err)
+		startCtx, cancel = s.dockerContext(ctx)
+		err = cli.ContainerStart(startCtx, contID, types.ContainerStartOptions{})
+		cancel()
+	}
+	endSpan(span, err)
+	if err != nil {
+		logger.Error("Error starting container", "err", err)
+		return
+	}
+	phases.StartMs = time.Since(startBegan).Milliseconds()
+	if app.Logs != nil {
+		go s.StreamLogs(app, contID, began)
+	}
 
 	// Wait for the container to start
-	err = func() error {
-		checkFreq := 100 * time.Millisecond
-		checkTimeout := 10 * time.Second
-		for i := 0; i < int(checkTimeout/checkFreq); i++ {
-			cont, err := cli.ContainerInspect(context.Background(), contID)
-			if err != nil {
-				log.Println("Error inspecting container: ", err.Error())
-				return err
-			}
-			if cont.State.Status != "running" {
-				return fmt.Errorf("container is not running")
-			}
-			health := types.NoHealthcheck
-			if cont.State.Health != nil {
-				health = cont.State.Health.Status
-			}
-			if health == types.NoHealthcheck {
-				if cont.State.Running {
-					log.Println("", app.Name, "container is reported running after", i*int(checkFreq/time.Millisecond), "ms")
-					return nil
-				}
-			} else if health == types.Healthy {
-				log.Println("", app.Name, "container is reported healthy after", i*int(checkFreq/time.Millisecond), "ms")
-				return nil
-			}
-			time.Sleep(checkFreq)
-		}
-		return fmt.Errorf("container did not start in time")
-	}()
+	_, span = tracer.Start(ctx, "readiness")
+	readinessBegan := time.Now()
+	err = s.WaitContainerReady(ctx, cli, app, contID)
+	endSpan(span, err)
 	if err != nil {
 		return
 	}
+	phases.ReadinessMs = time.Since(readinessBegan).Milliseconds()
+
+	func() {
+		s.ServerLock.Lock()
+		defer s.ServerLock.Unlock()
+		s.ServiceStartTime[app.Name] = s.Clock.Now()
+		phases.At = began
+		phases.TotalMs = time.Since(began).Milliseconds()
+		s.RecordColdStart(app, phases)
+	}()
+	s.Touch(app.Name)
+	err = s.TrackAllocations(cli, app, contID)
+	if err != nil {
+		logger.Error("Error tracking device allocations", "err", err)
+		err = nil
+	}
 
-	log.Println("Started container", contID, "for application", app.Name)
+	logger.Info("Started container", "container", contID)
+	s.Emit(EventStarted, app, nil)
+	s.AcquireDependencies(app)
+	s.RunHooks(cli, app, PostStart, contID)
 	return
 }
 
+// WaitContainerReady waits for a started container to report running, or healthy if it has a healthcheck.
+func (s *Server) WaitContainerReady(ctx context.Context, cli *client.Client, app Service, contID string) error {
+	checkFreq := 100 * time.Millisecond
+	checkTimeout := 10 * time.Second
+	for i := 0; i < int(checkTimeout/checkFreq); i++ {
+		inspectCtx, cancel := s.dockerContext(ctx)
+		cont, err := cli.ContainerInspect(inspectCtx, contID)
+		cancel()
+		if err != nil {
+			s.Logger.Error("Error inspecting container", "service", app.Name, "err", err)
+			return err
+		}
+		if cont.State.Status != "running" {
+			return fmt.Errorf("container is not running")
+		}
+		health := types.NoHealthcheck
+		if cont.State.Health != nil {
+			health = cont.State.Health.Status
+		}
+		if health == types.NoHealthcheck {
+			if cont.State.Running {
+				s.Logger.Info("Container is reported running", "service", app.Name, "ms", i*int(checkFreq/time.Millisecond))
+				return nil
+			}
+		} else if health == types.Healthy {
+			s.Logger.Info("Container is reported healthy", "service", app.Name, "ms", i*int(checkFreq/time.Millisecond))
+			return nil
+		}
+		if !sleep(ctx, checkFreq) {
+			return ctx.Err()
+		}
+	}
+	return fmt.Errorf("container did not start in time")
+}
+
 func (s *Server) StopContainer(name string) (err error) {
+	idleAction := None
+	if service := s.FindService(name); service != nil {
+		idleAction = service.IdleAction
+	}
+	return s.StopContainerWithAction(name, idleAction, false)
+}
+
+// StopContainerWithAction stops a container using the given idle action.
+// Unless forced, containers with active connections are left alone.
+func (s *Server) StopContainerWithAction(name string, idleAction string, force bool) (err error) {
+	logger := s.Logger.With("service", name)
 	s.ContainerAPILock.Lock(name)
 	defer s.ContainerAPILock.Unlock(name)
 
-	err = func() error {
-		s.ServerLock.Lock()
-		defer s.ServerLock.Unlock()
-		if count, ok := s.ServiceConnCount[name]; ok {
-			if count > 0 {
-				log.Println("Container", name, "has active connections, not stopping")
-				return fmt.Errorf("container has active connections")
-			}
-		}
-		return nil
-	}()
-	if err != nil {
-		return
+	if s.ConnCount(name) > 0 && !force {
+		logger.Info("Container has active connections, not stopping")
+		return fmt.Errorf("container has active connections")
 	}
 
-	cli, err := client.NewClientWithOpts(client.FromEnv)
+	cli, err := s.Docker(name)
 	if err != nil {
 		return
 	}
-	defer cli.Close()
-
-	containerName := name + "-goscalezero"
 
 	// Check if the container exists
 	var cont *types.Container
-
-	var list []types.Container
-	list, err = cli.ContainerList(context.Background(), types.ContainerListOptions{
-		All:     true,
-		Filters: filters.NewArgs(filters.KeyValuePair{Key: "name", Value: "/" + containerName}),
-	})
+	cont, err = s.FindContainer(cli, name)
 	if err != nil {
 		return
 	}
-searchlist:
-	for _, listcont := range list {
-		for _, name := range listcont.Names {
-			if name == "/"+containerName {
-				cont = &listcont
-				break searchlist
+
+	// Check if container is valid
+	if cont == nil {
+		err = ErrNoContainer
+		return
+	}
+
+	idleAction = strings.ToLower(idleAction)
+	stopOptions := container.StopOptions{}
+	service := s.FindService(name)
+	if service != nil {
+		stopOptions.Signal = service.StopSignal
+		stopOptions.Timeout = service.StopTimeout
+	}
+
+	if cont.State != "running" && cont.State != "paused" {
+		// already stopped, so it holds no reservation to release
+		logger.Debug("Container is not running", "container", cont.ID, "state", cont.State)
+		if idleAction == IdleRemove {
+			removeCtx, cancel := s.dockerContext(s.ctx)
+			err = cli.ContainerRemove(removeCtx, cont.ID, types.ContainerRemoveOptions{})
+			cancel()
+			if err != nil {
+				return
 			}
+			s.ForgetPortMappings(name)
+			s.Unplace(name)
 		}
+		return
 	}
 
-	// Check if container is valid
-	if cont == nil {
-		err = fmt.Errorf("container does not exist")
+	if idleAction == IdlePause {
+		if cont.State == "paused" {
+			return
+		}
+		// Freeze the container, keeping its memory (and reservation) for a fast wake
+		pauseCtx, cancel := s.dockerContext(s.ctx)
+		err = cli.ContainerPause(pauseCtx, cont.ID)
+		cancel()
+		if err != nil {
+			return
+		}
+		logger.Info("Paused container", "container", cont.ID)
+		if service != nil {
+			s.ReleaseDependencies(*service)
+		}
 		return
 	}
 
+	if service != nil {
+		err = s.RunHooks(cli, *service, PreStop, cont.ID)
+		if err != nil {
+			logger.Warn("Stopping despite failed hook", "stage", PreStop)
+			err = nil
+		}
+	}
+
 	// Stop command
-	err = cli.ContainerStop(context.Background(), cont.ID, container.StopOptions{})
-	if err != nil {
-		return
+	if service != nil {
+		s.setRestartPolicy(cli, *service, cont.ID, container.RestartPolicy{Name: "no"})
+	}
+	stopped := false
+	if idleAction == IdleCheckpoint {
+		// only one idle checkpoint is kept, it may not exist yet
+		checkpointCtx, cancel := s.stopContext(s.ctx, stopOptions.Timeout)
+		cli.CheckpointDelete(checkpointCtx, cont.ID, types.CheckpointDeleteOptions{CheckpointID: idleCheckpointID})
+		err = cli.CheckpointCreate(checkpointCtx, cont.ID, types.CheckpointCreateOptions{CheckpointID: idleCheckpointID, Exit: true})
+		cancel()
+		if err != nil {
+			logger.Warn("Error checkpointing container, stopping instead", "err", err)
+		} else {
+			logger.Info("Checkpointed container", "container", cont.ID)
+			stopped = true
+		}
+	}
+	if !stopped {
+		stopCtx, cancel := s.stopContext(s.ctx, stopOptions.Timeout)
+		err = cli.ContainerStop(stopCtx, cont.ID, stopOptions)
+		cancel()
+		if err != nil {
+			return
+		}
 	}
 
 	// Wait for the container to stop
-	ctxWithTimeout, cancelTimeout := context.WithTimeout(context.Background(), time.Second*10)
+	waitTimeout := time.Second * 10
+	if stopOptions.Timeout != nil && *stopOptions.Timeout > 0 {
+		waitTimeout += time.Duration(*stopOptions.Timeout) * time.Second
+	}
+	ctxWithTimeout, cancelTimeout := context.WithTimeout(s.ctx, waitTimeout)
 	defer cancelTimeout()
 	chWaitResp, chErr := cli.ContainerWait(ctxWithTimeout, cont.ID, container.WaitConditionNotRunning)
 	select {
@@ -658,26 +1580,43 @@ searchlist:
 	case <-chWaitResp:
 	}
 
-	log.Println("Stopped container", cont.ID, "for application", name)
-
-	func() {
-		var service *Service
-		for _, serv := range s.Config.Services {
-			if serv.Name == name {
-				service = &serv
-				break
-			}
+	logger.Info("Stopped container", "container", cont.ID)
+	if service != nil {
+		// a paused container already released its dependencies
+		if cont.State != "paused" {
+			s.ReleaseDependencies(*service)
 		}
-		if service == nil {
-			log.Println("Could not find service config for", name)
+		s.RunHooks(cli, *service, PostStop, cont.ID)
+		s.Emit(EventStopped, *service, nil)
+	}
+	func() {
+		s.ServerLock.Lock()
+		defer s.ServerLock.Unlock()
+		delete(s.ServiceStartTime, name)
+	}()
+
+	if service == nil {
+		logger.Warn("Could not find service config")
+	} else {
+		s.ReleaseResources(*service)
+	}
+
+	switch idleAction {
+	case IdleRemove:
+		removeCtx, cancel := s.dockerContext(s.ctx)
+		err = cli.ContainerRemove(removeCtx, cont.ID, types.ContainerRemoveOptions{})
+		cancel()
+		if err != nil {
 			return
 		}
-		s.TrackedResourcesLock.Lock()
-		defer s.TrackedResourcesLock.Unlock()
-		s.TrackedResources.MilliCPU -= service.ResourceRequest.MilliCPU
-		s.TrackedResources.MemoryMi -= service.ResourceRequest.MemoryMi
-		s.TrackedResources.GpuMemoryMi -= service.ResourceRequest.GpuMemoryMi
-	}()
+		logger.Info("Removed container", "container", cont.ID)
+
+		s.ForgetPortMappings(name)
+		s.Unplace(name)
+	case IdleStop, IdleCheckpoint, None: // keep the stopped container for a fast restart
+	default:
+		logger.Warn("Unknown idle action", "action", idleAction)
+	}
 
 	return
 }
@@ -701,30 +1640,3 @@ func (s *Server) ProcessTerminate() (err error) {
 	// TODO support executables
 	return
 }
-
-func main() {
-	configBuf, err := os.ReadFile("services.json")
-	if err != nil {
-		panic(err)
-	}
-	config := new(ServicesConfig)
-	if err = json.Unmarshal([]byte(configBuf), config); err != nil {
-		panic(err)
-	}
-
-	server := &Server{
-		Config:                  *config,
-		ServerLock:              sync.RWMutex{},
-		ServiceConnCount:        make(map[string]uint),
-		ServiceKillTime:         make(map[string]time.Time),
-		ServiceProxyHostPortMap: make(map[string]map[int]int),
-		TrackedResourcesLock:    sync.RWMutex{},
-		TrackedResources:        Resources{},
-		ContainerAPILock:        NewMutexMap(),
-	}
-	err = server.Start()
-	if err != nil {
-		log.Println("Error starting server: ", err.Error())
-		panic(err)
-	}
-}
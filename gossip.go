@@ -0,0 +1,306 @@
+package fishingboat
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// GossipConfig lets instances fronting the same services on different edge
+// IPs tell each other where each service is running, so a connection to an
+// instance that hasn't launched it goes to a peer's container instead of
+// starting a duplicate.
+type GossipConfig struct {
+	// address to take gossip on, e.g. :7946
+	Bind string `json:"bind"`
+	// the other instances' gossip addresses
+	Peers []string `json:"peers"`
+	// defaults to the hostname
+	Node string `json:"node,omitempty"`
+	// address peers reach our containers on, if serviceHostIP is loopback
+	AdvertiseIP string `json:"advertiseIP,omitempty"`
+	// shared by every peer and sent as a bearer token
+	Secret string `json:"secret,omitempty"`
+	// seconds between rounds, defaults to 1
+	Interval int `json:"interval,omitempty"`
+}
+
+// most a gossip exchange may carry either way, far more than the views of
+// any real set of peers
+const maxGossipBytes = 1 << 20
+
+// peerView is what one instance last said about itself.
+type peerView struct {
+	Node string `json:"node"`
+	// when the instance started, so its views win over ones from before a
+	// restart even though its seq started over
+	Incarnation int64  `json:"incarnation"`
+	Seq         uint64 `json:"seq"`
+	// backend address of each running service's container ports
+	Running map[string]map[int]string `json:"running,omitempty"`
+	// connections it is proxying to services running on other peers
+	Using map[string]uint `json:"using,omitempty"`
+	seen  time.Time
+}
+
+// Gossip spreads every instance's view to the others. A nil *Gossip has no
+// peers.
+type Gossip struct {
+	config   GossipConfig
+	interval time.Duration
+	client   *http.Client
+
+	lock        sync.Mutex
+	incarnation int64
+	seq         uint64
+	views       map[string]*peerView
+	using       map[string]uint
+}
+
+func NewGossip(config GossipConfig) (g *Gossip, err error) {
+	g = &Gossip{
+		config:      config,
+		interval:    time.Second,
+		incarnation: time.Now().UnixNano(),
+		views:       make(map[string]*peerView),
+		using:       make(map[string]uint),
+	}
+	if config.Interval > 0 {
+		g.interval = time.Duration(config.Interval) * time.Second
+	}
+	g.client = &http.Client{Timeout: g.interval * 2}
+	if g.config.Node == "" {
+		g.config.Node, err = os.Hostname()
+	}
+	return
+}
+
+// fresh reports whether a peer's view is recent enough to act on.
+func (g *Gossip) fresh(view *peerView) bool {
+	return view.Node != g.config.Node && time.Since(view.seen) < 3*g.interval
+}
+
+func (g *Gossip) merge(views []peerView) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for _, view := range views {
+		if view.Node == g.config.Node {
+			continue
+		}
+		if known, ok := g.views[view.Node]; ok && !newer(view, *known) {
+			continue
+		}
+		view := view
+		view.seen = time.Now()
+		g.views[view.Node] = &view
+	}
+}
+
+// newer reports whether view supersedes known, from the same instance.
+func newer(view peerView, known peerView) bool {
+	if view.Incarnation != known.Incarnation {
+		return view.Incarnation > known.Incarnation
+	}
+	return view.Seq > known.Seq
+}
+
+func (g *Gossip) snapshot() []peerView {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	views := make([]peerView, 0, len(g.views))
+	for _, view := range g.views {
+		if view.Node == g.config.Node || g.fresh(view) {
+			views = append(views, *view)
+		}
+	}
+	return views
+}
+
+// PeerBackend is where a peer is running a service's container port, or ""
+// if no peer is.
+func (g *Gossip) PeerBackend(name string, containerPort int) string {
+	if g == nil {
+		return ""
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for _, view := range g.views {
+		if g.fresh(view) && view.Running[name][containerPort] != "" {
+			return view.Running[name][containerPort]
+		}
+	}
+	return ""
+}
+
+// PeerBusy reports whether a peer is proxying connections to our container.
+func (g *Gossip) PeerBusy(name string) bool {
+	if g == nil {
+		return false
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for _, view := range g.views {
+		if g.fresh(view) && view.Using[name] > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Use counts a connection proxied to a peer's container, until Done.
+func (g *Gossip) Use(name string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.using[name]++
+}
+
+func (g *Gossip) Done(name string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.using[name]--
+	if g.using[name] == 0 {
+		delete(g.using, name)
+	}
+}
+
+// localView describes the containers this instance is running, at addresses
+// peers can reach.
+func (s *Server) localView() peerView {
+	view := peerView{Node: s.Gossip.config.Node, Running: make(map[string]map[int]string)}
+	for _, app := range s.Instances() {
+		running := false
+		func() {
+			s.ServerLock.RLock()
+			defer s.ServerLock.RUnlock()
+			_, running = s.ServiceStartTime[app.Name]
+		}()
+		if !running || len(app.Ports) == 0 {
+			continue
+		}
+		backends := make(map[int]string)
+		for _, port := range app.Ports {
+			if port.Direct() {
+				continue
+			}
+			backend := s.Backend(app, port)
+			host, hostPort, err := net.SplitHostPort(backend)
+			if err != nil {
+				continue
+			}
+			if ip := net.ParseIP(host); (ip == nil || ip.IsLoopback() || ip.IsUnspecified()) && s.Gossip.config.AdvertiseIP != "" {
+				backend = net.JoinHostPort(s.Gossip.config.AdvertiseIP, hostPort)
+			}
+			backends[port.ContainerPort] = backend
+		}
+		view.Running[app.Name] = backends
+	}
+	g := s.Gossip
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.seq++
+	view.Incarnation = g.incarnation
+	view.Seq = g.seq
+	view.Using = make(map[string]uint)
+	for name, count := range g.using {
+		view.Using[name] = count
+	}
+	view.seen = time.Now()
+	g.views[view.Node] = &view
+	return view
+}
+
+// RunGossip trades views with a random peer every interval.
+func (s *Server) RunGossip(ctx context.Context) {
+	g := s.Gossip
+	for sleep(ctx, g.interval) {
+		s.localView()
+		if len(g.config.Peers) == 0 {
+			continue
+		}
+		peer := g.config.Peers[rand.Intn(len(g.config.Peers))]
+		err := g.exchange(peer)
+		if err != nil {
+			s.Logger.Debug("Error gossiping with peer", "peer", peer, "err", err)
+		}
+	}
+}
+
+// exchange pushes our views to a peer and merges what it knows back.
+func (g *Gossip) exchange(peer string) (err error) {
+	body, err := json.Marshal(g.snapshot())
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), g.interval*2)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+peer+"/gossip", bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if g.config.Secret != "" {
+		req.Header.Set("Authorization", "Bearer "+g.config.Secret)
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("peer returned status %s", resp.Status)
+	}
+	var views []peerView
+	if err = json.NewDecoder(io.LimitReader(resp.Body, maxGossipBytes)).Decode(&views); err != nil {
+		return
+	}
+	g.merge(views)
+	return
+}
+
+func (s *Server) ServeGossip(ctx context.Context) {
+	g := s.Gossip
+	s.Logger.Info("Serving gossip", "bind", g.config.Bind, "node", g.config.Node)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/gossip", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if g.config.Secret != "" && subtle.ConstantTimeCompare([]byte(bearerToken(r)), []byte(g.config.Secret)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var views []peerView
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxGossipBytes)).Decode(&views)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "views too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		g.merge(views)
+		s.writeJSON(w, g.snapshot())
+	})
+	server := &http.Server{Addr: g.config.Bind, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	err := server.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		s.Logger.Error("Error serving gossip", "err", err)
+	}
+}
@@ -0,0 +1,172 @@
+package fishingboat
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+)
+
+// GpuMemory is the actual video memory use across all GPUs, per nvidia-smi.
+type GpuMemory struct {
+	UsedMi  int
+	TotalMi int
+}
+
+func nvidiaSmi(query string) ([][]int, error) {
+	out, err := exec.Command("nvidia-smi", query, "--format=csv,noheader,nounits").Output()
+	if err != nil {
+		return nil, err
+	}
+	records, err := csv.NewReader(bytes.NewReader(out)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	rows := make([][]int, 0, len(records))
+	for _, record := range records {
+		row := make([]int, 0, len(record))
+		for _, field := range record {
+			value, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil {
+				return nil, fmt.Errorf("unexpected nvidia-smi output %q: %w", field, err)
+			}
+			row = append(row, value)
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func QueryGpuMemory() (mem GpuMemory, err error) {
+	rows, err := nvidiaSmi("--query-gpu=memory.used,memory.total")
+	if err != nil {
+		return
+	}
+	for _, row := range rows {
+		mem.UsedMi += row[0]
+		mem.TotalMi += row[1]
+	}
+	return
+}
+
+// QueryGpuProcesses maps host PIDs of GPU compute processes to their video memory use.
+func QueryGpuProcesses() (map[int]int, error) {
+	rows, err := nvidiaSmi("--query-compute-apps=pid,used_memory")
+	if err != nil {
+		return nil, err
+	}
+	procs := make(map[int]int)
+	for _, row := range rows {
+		procs[row[0]] += row[1]
+	}
+	return procs, nil
+}
+
+// SampleGpu measures real video memory use, both card-wide and attributed to
+// running managed containers through their processes' cgroups.
+func (s *Server) SampleGpu() (err error) {
+	mem, err := QueryGpuMemory()
+	if err != nil {
+		return
+	}
+	procs, err := QueryGpuProcesses()
+	if err != nil {
+		return
+	}
+
+	cli, err := s.DockerHost("")
+	if err != nil {
+		return
+	}
+
+	// container ID -> service name
+	containers := make(map[string]string)
+	for _, app := range s.Instances() {
+		var cont *types.Container
+		cont, err = s.FindContainer(cli, app.Name)
+		if err != nil {
+			return
+		}
+		if cont != nil && cont.State == "running" {
+			containers[cont.ID] = app.Name
+		}
+	}
+
+	perService := make(map[string]int)
+	for pid, usedMi := range procs {
+		cgroup, err := os.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+		if err != nil {
+			continue // exited, or not visible from here
+		}
+		for id, name := range containers {
+			if bytes.Contains(cgroup, []byte(id)) {
+				perService[name] += usedMi
+				break
+			}
+		}
+	}
+
+	s.TrackedResourcesLock.Lock()
+	defer s.TrackedResourcesLock.Unlock()
+	s.MeasuredGpu = &mem
+	for name, usage := range s.MeasuredResources {
+		usage.GpuMemoryMi = perService[name]
+		s.MeasuredResources[name] = usage
+	}
+	return
+}
+
+// GpuRequest selects which GPUs a service gets. Without DeviceIDs or Count, all GPUs are requested.
+type GpuRequest struct {
+	Driver    string   `json:"driver,omitempty"`
+	Count     int      `json:"count,omitempty"`
+	DeviceIDs []string `json:"deviceIDs,omitempty"`
+}
+
+// AssignGpus picks the least loaded GPUs from the configured pool for a
+// service asking for a count of GPUs, or nil if it doesn't need assigning.
+// They are reserved for app as they are picked, so concurrent launches
+// spread out. ReleaseGpus gives them back if the launch fails.
+func (s *Server) AssignGpus(app Service) []string {
+	if app.Gpus == nil || app.Gpus.Count <= 0 || len(app.Gpus.DeviceIDs) > 0 {
+		return nil
+	}
+	pool := s.Config.Resources.Gpus
+	if len(pool) == 0 {
+		return nil
+	}
+
+	s.TrackedResourcesLock.Lock()
+	defer s.TrackedResourcesLock.Unlock()
+	// load is the video memory requested by services on each device
+	load := make(map[string]int)
+	for name, ids := range s.GpuAllocations {
+		memoryMi := 0
+		if serv := s.FindService(name); serv != nil && serv.ResourceRequest != nil {
+			memoryMi = serv.ResourceRequest.GpuMemoryMi
+		}
+		for _, id := range ids {
+			load[id] += 1 + memoryMi
+		}
+	}
+	candidates := append([]string{}, pool...)
+	sort.SliceStable(candidates, func(i, j int) bool { return load[candidates[i]] < load[candidates[j]] })
+	if app.Gpus.Count < len(candidates) {
+		candidates = candidates[:app.Gpus.Count]
+	}
+	s.GpuAllocations[app.Name] = candidates
+	return candidates
+}
+
+// ReleaseGpus drops the GPUs reserved for app.
+func (s *Server) ReleaseGpus(app Service) {
+	s.TrackedResourcesLock.Lock()
+	defer s.TrackedResourcesLock.Unlock()
+	delete(s.GpuAllocations, app.Name)
+}
@@ -0,0 +1,83 @@
+package fishingboat
+
+import (
+	"context"
+	"time"
+)
+
+// GroupMembers returns every service sharing app's group, including app itself.
+func (s *Server) GroupMembers(app Service) []Service {
+	if app.Group == "" {
+		return []Service{app}
+	}
+	members := make([]Service, 0)
+	for _, serv := range s.Config.Services {
+		if serv.Group == app.Group {
+			members = append(members, serv)
+		}
+	}
+	return members
+}
+
+// GroupConnCount sums connections across app's group.
+func (s *Server) GroupConnCount(app Service) (count uint) {
+	for _, member := range s.GroupMembers(app) {
+		count += s.ConnCount(member.Name)
+	}
+	return
+}
+
+func (s *Server) LaunchGroup(ctx context.Context, app Service) (err error) {
+	err = s.LaunchContainer(ctx, app)
+	if err != nil {
+		return
+	}
+	for _, member := range s.GroupMembers(app) {
+		if member.Name == app.Name {
+			continue
+		}
+		s.Logger.Info("Launching group member", "service", member.Name, "group", app.Group)
+		err = s.LaunchContainer(ctx, member)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// ScheduleGroupKill gives every member of app's group a deadline once the
+// whole group is idle.
+func (s *Server) ScheduleGroupKill(app Service) {
+	if s.GroupConnCount(app) > 0 {
+		return
+	}
+	for _, member := range s.GroupMembers(app) {
+		s.SetKillTime(member.Name, s.Clock.Now().Add(time.Duration(member.CoolDown)*time.Second))
+	}
+	// a connection that came in meanwhile may have cancelled before we set
+	if s.GroupConnCount(app) > 0 {
+		s.CancelGroupKill(app)
+	}
+}
+
+// CancelGroupKill drops any pending shutdown of app's group after it is
+// re-woken during cooldown.
+func (s *Server) CancelGroupKill(app Service) {
+	for _, member := range s.GroupMembers(app) {
+		s.ClearKillTime(member.Name)
+	}
+}
+
+// RemainingCooldown reports how long until name is scaled down, if a
+// shutdown is scheduled.
+func (s *Server) RemainingCooldown(name string) (time.Duration, bool) {
+	killTime, ok := s.KillTime(name)
+	if !ok {
+		return 0, false
+	}
+	remaining := killTime.Sub(s.Clock.Now())
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining, true
+}
@@ -0,0 +1,238 @@
+package fishingboat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	ElectionRedis  = "redis"
+	ElectionSignal = "signal"
+)
+
+const (
+	HAPromote = "promote"
+	HADemote  = "demote"
+)
+
+// ErrSteppedDown is what Start returns after losing leadership. The caller
+// should restart it, so it stands by again.
+var ErrSteppedDown = errors.New("stepped down as leader")
+
+// HAConfig runs fishingboat as half of an active/standby pair. The standby
+// doesn't listen or touch containers until it becomes leader, then adopts
+// the containers and state the old leader left behind.
+type HAConfig struct {
+	// redis (default) holds a lease in the cluster's Redis. signal waits for
+	// SIGUSR1 to lead and SIGUSR2 to step down, e.g. from a keepalived
+	// notify script.
+	Election string `json:"election,omitempty"`
+	// seconds a leader lasts without renewing, defaults to 10
+	Lease int `json:"lease,omitempty"`
+	// commands or webhooks run on taking over, e.g. to claim a shared VIP,
+	// and on stepping down
+	OnPromote []Hook `json:"onPromote,omitempty"`
+	OnDemote  []Hook `json:"onDemote,omitempty"`
+}
+
+func (c *HAConfig) lease() time.Duration {
+	if c.Lease > 0 {
+		return time.Duration(c.Lease) * time.Second
+	}
+	return 10 * time.Second
+}
+
+var campaignScript = redis.NewScript(`
+local holder = redis.call("get", KEYS[1])
+if holder == false then
+	redis.call("set", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return 1
+end
+if holder == ARGV[1] then
+	redis.call("pexpire", KEYS[1], ARGV[2])
+	return 1
+end
+return 0`)
+
+// Campaign takes or renews the leader lease, reporting whether we hold it.
+func (c *Cluster) Campaign(ctx context.Context, lease time.Duration) (bool, error) {
+	won, err := campaignScript.Run(ctx, c.client, []string{c.key("leader")}, c.node, lease.Milliseconds()).Int()
+	return won == 1, err
+}
+
+// PublishState shares the leader's state so a standby can adopt it.
+func (c *Cluster) PublishState(ctx context.Context, states map[string]ServiceState) error {
+	if c == nil {
+		return nil
+	}
+	buf, err := json.Marshal(states)
+	if err != nil {
+		return err
+	}
+	return c.client.Set(ctx, c.key("state"), buf, 0).Err()
+}
+
+func (c *Cluster) LoadState(ctx context.Context) (states map[string]ServiceState, err error) {
+	states = make(map[string]ServiceState)
+	if c == nil {
+		return
+	}
+	buf, err := c.client.Get(ctx, c.key("state")).Bytes()
+	if err == redis.Nil {
+		return states, nil
+	}
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(buf, &states)
+	return
+}
+
+// AwaitLeadership blocks a standby until it becomes leader, runs the promote
+// hooks, then keeps leading in the background.
+func (s *Server) AwaitLeadership(ctx context.Context) (err error) {
+	ha := s.Config.HA
+	switch ha.Election {
+	case ElectionRedis, None:
+		if s.Cluster == nil {
+			return fmt.Errorf("redis election needs the cluster to be configured")
+		}
+		s.Logger.Info("Standing by for leadership", "node", s.Cluster.node)
+		for {
+			campaignCtx, cancel := context.WithTimeout(ctx, ha.lease()/2)
+			var won bool
+			won, err = s.Cluster.Campaign(campaignCtx, ha.lease())
+			cancel()
+			if err != nil {
+				s.Logger.Error("Error campaigning for leadership", "err", err)
+			} else if won {
+				break
+			}
+			if !sleep(ctx, ha.lease()/3) {
+				return ctx.Err()
+			}
+		}
+	case ElectionSignal:
+		if promoteSignal == nil {
+			return fmt.Errorf("signal election is not available on this platform")
+		}
+		promote := make(chan os.Signal, 1)
+		signal.Notify(promote, promoteSignal)
+		s.Logger.Info("Standing by for SIGUSR1")
+		defer signal.Stop(promote)
+		select {
+		case <-promote:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	default:
+		return fmt.Errorf("unknown election %s", ha.Election)
+	}
+
+	s.Logger.Info("Became leader")
+	s.runHAHooks(HAPromote, ha.OnPromote)
+	go s.Lead(ctx)
+	return nil
+}
+
+// Lead renews the lease and shares state until leadership is lost, then
+// steps down. Failing to renew for longer than the lease counts as lost,
+// since the standby may have taken over by then.
+func (s *Server) Lead(ctx context.Context) {
+	ha := s.Config.HA
+	// the lease is only good from when its renewal was asked for
+	renewed := time.Now()
+	demote := make(chan os.Signal, 1)
+	if ha.Election == ElectionSignal {
+		signal.Notify(demote, demoteSignal)
+		defer signal.Stop(demote)
+	}
+	for {
+		select {
+		case <-demote:
+			s.StepDown("told to by SIGUSR2")
+			return
+		case <-ctx.Done():
+			return
+		case <-time.After(ha.lease() / 3):
+		}
+		renewCtx, cancel := context.WithTimeout(ctx, ha.lease()/3)
+		if ha.Election != ElectionSignal {
+			asked := time.Now()
+			won, err := s.Cluster.Campaign(renewCtx, ha.lease())
+			switch {
+			case err != nil:
+				s.Logger.Error("Error renewing leadership", "err", err)
+			case !won:
+				cancel()
+				s.StepDown("lease was taken")
+				return
+			default:
+				renewed = asked
+			}
+			if time.Since(renewed) > ha.lease() {
+				cancel()
+				s.StepDown("lease expired without being renewed")
+				return
+			}
+		}
+		err := s.Cluster.PublishState(renewCtx, s.SnapshotState())
+		cancel()
+		if err != nil {
+			s.Logger.Error("Error sharing state", "err", err)
+		}
+	}
+}
+
+// StepDown runs the demote hooks and stops Start, which returns
+// ErrSteppedDown. Containers are left running for the new leader to adopt.
+func (s *Server) StepDown(reason string) {
+	s.Logger.Error("Stepping down as leader", "reason", reason)
+	s.runHAHooks(HADemote, s.Config.HA.OnDemote)
+	if s.stop != nil {
+		s.stop(ErrSteppedDown)
+	}
+}
+
+// runHAHooks runs host commands and webhooks for a leadership change,
+// carrying on past failures.
+func (s *Server) runHAHooks(event string, hooks []Hook) {
+	node, _ := os.Hostname()
+	for _, hook := range hooks {
+		timeout := 60 * time.Second
+		if hook.Timeout > 0 {
+			timeout = time.Duration(hook.Timeout) * time.Second
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		var err error
+		switch {
+		case len(hook.Command) > 0:
+			cmd := exec.CommandContext(ctx, hook.Command[0], hook.Command[1:]...)
+			cmd.Env = append(os.Environ(), "FISHINGBOAT_HA="+event)
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+			err = cmd.Run()
+		case hook.URL != "":
+			var body []byte
+			body, err = json.Marshal(map[string]string{"event": event, "node": node})
+			if err == nil {
+				err = postHook(ctx, hook.URL, body, io.Discard)
+			}
+		default:
+			err = fmt.Errorf("leadership hooks need a command or url")
+		}
+		cancel()
+		if err != nil {
+			s.Logger.Error("Error running leadership hook", "event", event, "err", err)
+		}
+	}
+}
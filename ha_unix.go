@@ -0,0 +1,14 @@
+//go:build !windows
+
+package fishingboat
+
+import (
+	"os"
+	"syscall"
+)
+
+// signals a keepalived notify script sends for the signal election
+var (
+	promoteSignal os.Signal = syscall.SIGUSR1
+	demoteSignal  os.Signal = syscall.SIGUSR2
+)
@@ -0,0 +1,9 @@
+package fishingboat
+
+import "os"
+
+// Windows has no user signals, so only the redis election works there.
+var (
+	promoteSignal os.Signal
+	demoteSignal  os.Signal
+)
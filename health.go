@@ -0,0 +1,195 @@
+package fishingboat
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+const (
+	UnhealthyRestart  = "restart"
+	UnhealthyRecreate = "recreate"
+)
+
+// Healthcheck gives a service's container a Docker HEALTHCHECK, replacing any
+// the image has. Readiness and unhealthyAction then follow it.
+type Healthcheck struct {
+	// ["CMD", args...], ["CMD-SHELL", command], or ["NONE"] to turn off the
+	// image's check. A list without one of those is run as CMD.
+	Test []string `json:"test"`
+	// seconds, zero leaves Docker's defaults
+	Interval    int `json:"interval,omitempty"`
+	Timeout     int `json:"timeout,omitempty"`
+	StartPeriod int `json:"startPeriod,omitempty"`
+	// failures in a row before the container is unhealthy
+	Retries int `json:"retries,omitempty"`
+}
+
+// Apply sets the healthcheck on a container's create config.
+func (h *Healthcheck) Apply(config *container.Config) {
+	if h == nil {
+		return
+	}
+	test := h.Test
+	if len(test) > 0 && test[0] != "NONE" && test[0] != "CMD" && test[0] != "CMD-SHELL" {
+		test = append([]string{"CMD"}, test...)
+	}
+	config.Healthcheck = &container.HealthConfig{
+		Test:        test,
+		Interval:    time.Duration(h.Interval) * time.Second,
+		Timeout:     time.Duration(h.Timeout) * time.Second,
+		StartPeriod: time.Duration(h.StartPeriod) * time.Second,
+		Retries:     h.Retries,
+	}
+}
+
+// WatchHealth keeps an eye on running containers after startup and
+// remediates ones that turn unhealthy according to their unhealthyAction.
+func (s *Server) WatchHealth(ctx context.Context) {
+	for sleep(ctx, 5*time.Second) {
+		for _, app := range s.Instances() {
+			switch strings.ToLower(app.UnhealthyAction) {
+			case UnhealthyRestart, UnhealthyRecreate:
+			case None:
+				continue
+			default:
+				s.Logger.Warn("Unknown unhealthy action", "service", app.Name, "action", app.UnhealthyAction)
+				continue
+			}
+			running := false
+			func() {
+				s.ServerLock.Lock()
+				defer s.ServerLock.Unlock()
+				_, running = s.ServiceStartTime[app.Name]
+				running = running && !s.ServiceRemediating[app.Name]
+			}()
+			if !running {
+				continue
+			}
+			healthy, err := s.ContainerHealthy(app)
+			if err != nil {
+				s.Logger.Error("Error checking health", "service", app.Name, "err", err)
+				continue
+			}
+			if !healthy {
+				func() {
+					s.ServerLock.Lock()
+					defer s.ServerLock.Unlock()
+					s.ServiceRemediating[app.Name] = true
+				}()
+				go s.RemediateUnhealthy(app)
+			}
+		}
+	}
+}
+
+func (s *Server) ContainerHealthy(app Service) (healthy bool, err error) {
+	cli, err := s.Docker(app.Name)
+	if err != nil {
+		return
+	}
+
+	var cont *types.Container
+	cont, err = s.FindContainer(cli, app.Name)
+	if err != nil || cont == nil || cont.State != "running" {
+		return true, err
+	}
+	ctx, cancel := s.dockerContext(s.ctx)
+	defer cancel()
+	var inspect types.ContainerJSON
+	inspect, err = cli.ContainerInspect(ctx, cont.ID)
+	if err != nil {
+		return
+	}
+	return inspect.State.Health == nil || inspect.State.Health.Status != types.Unhealthy, nil
+}
+
+func (s *Server) RemediateUnhealthy(app Service) {
+	defer func() {
+		s.ServerLock.Lock()
+		defer s.ServerLock.Unlock()
+		delete(s.ServiceRemediating, app.Name)
+	}()
+	s.Logger.Warn("Service is unhealthy, draining connections", "service", app.Name)
+	s.AuditReason(AuditRemediate, app, "unhealthy, "+app.UnhealthyAction, "", nil)
+
+	// give proxied connections a chance to finish
+	s.waitDrained(app, time.Duration(app.DrainTimeout)*time.Second)
+
+	var err error
+	switch strings.ToLower(app.UnhealthyAction) {
+	case UnhealthyRestart:
+		err = s.RestartContainer(app)
+	case UnhealthyRecreate:
+		err = s.RecreateContainer(app)
+	}
+	if err != nil {
+		s.Logger.Error("Error remediating unhealthy service", "service", app.Name, "err", err)
+	}
+}
+
+func (s *Server) RestartContainer(app Service) (err error) {
+	s.ContainerAPILock.Lock(app.Name)
+	defer s.ContainerAPILock.Unlock(app.Name)
+
+	cli, err := s.Docker(app.Name)
+	if err != nil {
+		return
+	}
+
+	var cont *types.Container
+	cont, err = s.FindContainer(cli, app.Name)
+	if err != nil || cont == nil {
+		return
+	}
+	ctx, cancel := s.stopContext(s.ctx, app.StopTimeout)
+	err = cli.ContainerRestart(ctx, cont.ID, container.StopOptions{Signal: app.StopSignal, Timeout: app.StopTimeout})
+	cancel()
+	if err != nil {
+		return
+	}
+	// the backend ports are picked again on start
+	err = s.LoadPortMappings(cli, app, cont.ID)
+	if err != nil {
+		return
+	}
+	err = s.WaitContainerReady(s.ctx, cli, app, cont.ID)
+	if err != nil {
+		return
+	}
+	s.Logger.Info("Restarted container", "service", app.Name, "container", cont.ID)
+	return
+}
+
+// RecreateContainer replaces the container even if connections are still
+// open, then brings the service straight back up for them.
+func (s *Server) RecreateContainer(app Service) (err error) {
+	err = func() (err error) {
+		s.ContainerAPILock.Lock(app.Name)
+		defer s.ContainerAPILock.Unlock(app.Name)
+
+		cli, err := s.Docker(app.Name)
+		if err != nil {
+			return
+		}
+
+		var cont *types.Container
+		cont, err = s.FindContainer(cli, app.Name)
+		if err != nil || cont == nil {
+			return
+		}
+		err = s.RemoveContainer(cli, app, cont)
+		if err != nil {
+			return
+		}
+		s.Logger.Info("Removed container", "service", app.Name, "container", cont.ID)
+		return
+	}()
+	if err != nil {
+		return
+	}
+	return s.LaunchContainer(s.ctx, app)
+}
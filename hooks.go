@@ -0,0 +1,163 @@
+package fishingboat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+const (
+	PreStart  = "preStart"
+	PostStart = "postStart"
+	PreStop   = "preStop"
+	PostStop  = "postStop"
+)
+
+// Hook runs exactly one of a host command, an exec inside the container, or an HTTP webhook.
+type Hook struct {
+	Command []string `json:"command,omitempty"`
+	Exec    []string `json:"exec,omitempty"`
+	URL     string   `json:"url,omitempty"`
+	Timeout int      `json:"timeout,omitempty"`
+}
+
+type Hooks struct {
+	PreStart  []Hook `json:"preStart,omitempty"`
+	PostStart []Hook `json:"postStart,omitempty"`
+	PreStop   []Hook `json:"preStop,omitempty"`
+	PostStop  []Hook `json:"postStop,omitempty"`
+}
+
+func (h *Hooks) Stage(stage string) []Hook {
+	if h == nil {
+		return nil
+	}
+	switch stage {
+	case PreStart:
+		return h.PreStart
+	case PostStart:
+		return h.PostStart
+	case PreStop:
+		return h.PreStop
+	case PostStop:
+		return h.PostStop
+	}
+	return nil
+}
+
+// RunHooks runs the hooks for a lifecycle stage in order, stopping at the first failure.
+func (s *Server) RunHooks(cli *client.Client, app Service, stage string, contID string) (err error) {
+	for _, hook := range app.Hooks.Stage(stage) {
+		s.Logger.Info("Running hook", "service", app.Name, "stage", stage)
+		err = s.RunHook(cli, app, stage, hook, contID)
+		if err != nil {
+			s.Logger.Error("Error running hook", "service", app.Name, "stage", stage, "err", err)
+			return
+		}
+	}
+	return
+}
+
+func (s *Server) RunHook(cli *client.Client, app Service, stage string, hook Hook, contID string) (err error) {
+	timeout := 60 * time.Second
+	if hook.Timeout > 0 {
+		timeout = time.Duration(hook.Timeout) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, timeout)
+	defer cancel()
+
+	switch {
+	case len(hook.Command) > 0:
+		cmd := exec.CommandContext(ctx, hook.Command[0], hook.Command[1:]...)
+		cmd.Env = append(os.Environ(), "FISHINGBOAT_SERVICE="+app.Name, "FISHINGBOAT_STAGE="+stage)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		return cmd.Run()
+
+	case len(hook.Exec) > 0:
+		if stage == PreStart || stage == PostStop {
+			return fmt.Errorf("exec hooks need a running container and cannot be used for %s", stage)
+		}
+		return ExecInContainer(ctx, cli, contID, hook.Exec, os.Stdout)
+
+	case hook.URL != "":
+		var body []byte
+		body, err = json.Marshal(map[string]string{"service": app.Name, "stage": stage, "container": contID})
+		if err != nil {
+			return
+		}
+		return postHook(ctx, hook.URL, body, io.Discard)
+	}
+	return fmt.Errorf("hook has no command, exec, or url")
+}
+
+// ExecInContainer runs cmd in a running container, copying its stdout to
+// stdout and its stderr to ours.
+func ExecInContainer(ctx context.Context, cli *client.Client, contID string, cmd []string, stdout io.Writer) (err error) {
+	var execID types.IDResponse
+	execID, err = cli.ContainerExecCreate(ctx, contID, types.ExecConfig{
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return
+	}
+	var resp types.HijackedResponse
+	resp, err = cli.ContainerExecAttach(ctx, execID.ID, types.ExecStartCheck{})
+	if err != nil {
+		return
+	}
+	defer resp.Close()
+	_, err = stdcopy.StdCopy(stdout, os.Stderr, resp.Reader)
+	if err != nil {
+		return
+	}
+	var inspect types.ContainerExecInspect
+	inspect, err = cli.ContainerExecInspect(ctx, execID.ID)
+	if err != nil {
+		return
+	}
+	if inspect.ExitCode != 0 {
+		return fmt.Errorf("exec exited with code %d", inspect.ExitCode)
+	}
+	return
+}
+
+// postHook posts body to url, or just GETs it if body is nil, copying the
+// response to out.
+func postHook(ctx context.Context, url string, body []byte, out io.Writer) (err error) {
+	method := http.MethodPost
+	if body == nil {
+		method = http.MethodGet
+	}
+	var req *http.Request
+	req, err = http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	var resp *http.Response
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %s", resp.Status)
+	}
+	_, err = io.Copy(out, resp.Body)
+	return
+}
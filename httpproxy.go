@@ -0,0 +1,170 @@
+package fishingboat
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	ProtocolTCP  = "tcp"
+	ProtocolHTTP = "http"
+	ProtocolUDP  = "udp"
+	ProtocolSCTP = "sctp"
+)
+
+// ServeHTTPPort proxies HTTP on listener. Each request holds the service awake
+// rather than each connection, so browsers keeping idle connections open
+// don't stop it from scaling down once requests stop coming. Connections
+// closed before sending a request count as strikes towards a ban.
+func (s *Server) ServeHTTPPort(listener net.Listener, app Service, port PortMapping) error {
+	// connections that haven't sent a request yet
+	var empty sync.Map
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s.HandleRequest(w, r, app, port)
+		}),
+		ReadHeaderTimeout: 30 * time.Second,
+		ConnState: func(conn net.Conn, state http.ConnState) {
+			switch state {
+			case http.StateNew:
+				empty.Store(conn, struct{}{})
+			case http.StateActive, http.StateHijacked:
+				empty.Delete(conn)
+			case http.StateClosed:
+				if _, ok := empty.LoadAndDelete(conn); ok {
+					s.Strike(app, conn.RemoteAddr().String())
+				}
+			}
+		},
+	}
+	return server.Serve(listener)
+}
+
+func (s *Server) HandleRequest(w http.ResponseWriter, r *http.Request, app Service, port PortMapping) {
+	client, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	if err != nil {
+		client = nil
+	}
+	app = s.Successor(s.PickRequestReplica(w, r, app, client))
+	logger := s.Logger.With("service", app.Name, "port", port.ContainerPort, "remote", r.RemoteAddr, "path", r.URL.Path)
+	ctx, span := tracer.Start(WithTrigger(r.Context(), "request", r.RemoteAddr), "request", trace.WithAttributes(
+		attribute.String("service", app.Name),
+		attribute.Int("port", port.ContainerPort),
+		attribute.String("remote", r.RemoteAddr),
+		attribute.String("method", r.Method),
+	))
+	defer span.End()
+	entry := access{began: time.Now()}
+	defer func() { s.LogAccess(app, r.RemoteAddr, entry) }()
+	// scanners shouldn't be able to boot anything
+	if !s.Authenticate(w, r, app) {
+		entry.cause = "unauthorized"
+		return
+	}
+	containerActive := s.ConnCount(app.Name) > 0
+	running := false
+	func() {
+		s.ServerLock.RLock()
+		defer s.ServerLock.RUnlock()
+		_, running = s.ServiceStartTime[app.Name]
+	}()
+	peer := ""
+	if !running {
+		peer = s.Gossip.PeerBackend(app.Name, port.ContainerPort)
+	}
+	span.SetAttributes(attribute.Bool("cold", !containerActive && peer == ""))
+	entry.cold = !containerActive && peer == ""
+	s.StatsD.Count("requests", 1, "service:"+app.Name, fmt.Sprintf("cold:%t", entry.cold))
+	if entry.cold && s.Banned(r.RemoteAddr) {
+		logger.Debug("Refusing to wake for banned source")
+		entry.cause = "banned"
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if entry.cold {
+		wakeCtx, wakeSpan := tracer.Start(ctx, "wake")
+		err := s.WaitForStartup(wakeCtx, app)
+		endSpan(wakeSpan, err)
+		if err != nil {
+			logger.Error("Error launching container", "err", err)
+			span.SetStatus(codes.Error, err.Error())
+			entry.cause = "launch: " + err.Error()
+			http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	if peer != "" {
+		s.Gossip.Use(app.Name)
+		defer s.Gossip.Done(app.Name)
+		entry.backend = peer
+	} else {
+		defer s.Acquire(app, port)()
+		entry.backend = s.Backend(app, port)
+	}
+	transport, err := s.BackendTransport(app)
+	if err != nil {
+		logger.Error("Error setting up backend TLS", "err", err)
+		entry.cause = "tls: " + err.Error()
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+		return
+	}
+	scheme := "http"
+	if app.BackendTLS != nil {
+		scheme = "https"
+	}
+	proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: scheme, Host: entry.backend})
+	proxy.Transport = transport
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		logger.Error("Error proxying request", "err", err)
+		var opErr *net.OpError
+		if peer == "" && errors.As(err, &opErr) && opErr.Op == "dial" {
+			// the request can't be replayed, but the next one goes to
+			// the container's new port if it moved
+			s.RefreshPortMappings(app)
+		}
+		span.SetStatus(codes.Error, err.Error())
+		entry.cause = "proxy: " + err.Error()
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	counter := &countingWriter{ResponseWriter: w}
+	proxy.ServeHTTP(counter, r.WithContext(ctx))
+	if r.ContentLength > 0 {
+		entry.bytesIn = r.ContentLength
+	}
+	entry.bytesOut = counter.n
+	logger.Debug("Proxied request", "method", r.Method)
+}
+
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Unwrap lets upgraded connections like websockets hijack the real writer.
+func (c *countingWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// Flush keeps streamed responses streaming.
+func (c *countingWriter) Flush() {
+	if flusher, ok := c.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
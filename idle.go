@@ -0,0 +1,168 @@
+package fishingboat
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	IdleConnections = "connections"
+	IdleTraffic     = "traffic"
+	IdleCPU         = "cpu"
+)
+
+// trafficWriter stamps the service's traffic clock on every write.
+type trafficWriter struct {
+	w     io.Writer
+	clock *atomic.Int64
+	now   Clock
+}
+
+func (t trafficWriter) Write(p []byte) (int, error) {
+	t.clock.Store(t.now.Now().UnixNano())
+	return t.w.Write(p)
+}
+
+// TrafficClock returns the time of the last byte proxied for name, creating
+// it if needed.
+func (s *Server) TrafficClock(name string) *atomic.Int64 {
+	s.ServerLock.Lock()
+	defer s.ServerLock.Unlock()
+	clock, ok := s.ServiceLastTraffic[name]
+	if !ok {
+		clock = new(atomic.Int64)
+		s.ServiceLastTraffic[name] = clock
+	}
+	return clock
+}
+
+// CpuBusy reports whether a service in the cpu idle mode went over its
+// idleMcpu within the last cooldown. ServerLock must be held.
+func (s *Server) CpuBusy(app Service) bool {
+	if strings.ToLower(app.IdleMode) != IdleCPU {
+		return false
+	}
+	return s.Clock.Now().Sub(s.ServiceLastBusy[app.Name]) < time.Duration(app.CoolDown)*time.Second
+}
+
+func idleTimeout(app Service) time.Duration {
+	if app.IdleTimeout > 0 {
+		return time.Duration(app.IdleTimeout) * time.Second
+	}
+	return time.Duration(app.CoolDown) * time.Second
+}
+
+// WatchTraffic stops services in the traffic idle mode once no bytes have
+// moved for their idleTimeout, even while clients hold connections open.
+// Services with no connections at all are left to the usual cooldown.
+func (s *Server) WatchTraffic(ctx context.Context) {
+	for sleep(ctx, 1*time.Second) {
+		for _, app := range s.Instances() {
+			if strings.ToLower(app.IdleMode) != IdleTraffic {
+				continue
+			}
+			idle := false
+			func() {
+				s.ServerLock.RLock()
+				defer s.ServerLock.RUnlock()
+				startTime, running := s.ServiceStartTime[app.Name]
+				clock, ok := s.ServiceLastTraffic[app.Name]
+				if !running || !ok || s.ConnCount(app.Name) == 0 {
+					return
+				}
+				now := s.Clock.Now()
+				if now.Before(s.ServiceWarmUntil[app.Name]) || now.Sub(startTime) < time.Duration(app.MinUptime)*time.Second {
+					return
+				}
+				idle = now.Sub(time.Unix(0, clock.Load())) > idleTimeout(app)
+			}()
+			if !idle {
+				continue
+			}
+			unlock, ok := s.Cluster.TryLock(ctx, app.Name)
+			if !ok {
+				continue
+			}
+			s.Logger.Info("Stopping container with no traffic", "service", app.Name)
+			action := app.IdleAction
+			if strings.ToLower(action) == IdlePause {
+				// a paused container would leave its open connections hanging
+				action = IdleStop
+			}
+			err := s.StopContainerWithAction(app.Name, action, true)
+			unlock()
+			if err != nil {
+				s.Logger.Error("Error stopping container", "service", app.Name, "err", err)
+				continue
+			}
+			s.AuditLog.Record(AuditEntry{Action: AuditStop, Service: app.Name, Reason: "no traffic"})
+			s.ClearKillTime(app.Name)
+		}
+	}
+}
+
+// ProbeBusy asks a service's idleProbe whether anyone is still using it. The
+// probe answers with a count in its output, e.g. players online, and
+// anything above zero means busy. A probe that can't run doesn't hold the
+// service up.
+func (s *Server) ProbeBusy(app Service) bool {
+	probe := app.IdleProbe
+	if probe == nil {
+		return false
+	}
+	timeout := 10 * time.Second
+	if probe.Timeout > 0 {
+		timeout = time.Duration(probe.Timeout) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, timeout)
+	defer cancel()
+
+	var out bytes.Buffer
+	err := func() (err error) {
+		switch {
+		case len(probe.Command) > 0:
+			cmd := exec.CommandContext(ctx, probe.Command[0], probe.Command[1:]...)
+			cmd.Env = append(os.Environ(), "FISHINGBOAT_SERVICE="+app.Name)
+			cmd.Stdout = &out
+			cmd.Stderr = os.Stderr
+			return cmd.Run()
+
+		case len(probe.Exec) > 0:
+			cli, err := s.Docker(app.Name)
+			if err != nil {
+				return err
+			}
+			cont, err := s.FindContainer(cli, app.Name)
+			if err != nil || cont == nil {
+				return err
+			}
+			return ExecInContainer(ctx, cli, cont.ID, probe.Exec, &out)
+
+		case probe.URL != "":
+			return postHook(ctx, probe.URL, nil, &out)
+		}
+		return fmt.Errorf("probe has no command, exec, or url")
+	}()
+	if err != nil {
+		s.Logger.Warn("Error running idle probe", "service", app.Name, "err", err)
+		return false
+	}
+	answer := strings.TrimSpace(out.String())
+	if answer == "" {
+		return false
+	}
+	count, err := strconv.ParseFloat(answer, 64)
+	if err != nil {
+		s.Logger.Warn("Idle probe did not answer with a number", "service", app.Name, "answer", answer)
+		return false
+	}
+	return count > 0
+}
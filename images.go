@@ -0,0 +1,187 @@
+package fishingboat
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// IsDigestReference reports whether image is pinned by digest, e.g. name@sha256:...
+func IsDigestReference(image string) bool {
+	return strings.Contains(image, "@sha256:")
+}
+
+// ContainerImageMatches compares the container's image ID against what the
+// configured reference currently resolves to, so moved tags and digest pins
+// are detected rather than just comparing reference strings.
+func ContainerImageMatches(ctx context.Context, cli *client.Client, cont *types.Container, image string) (bool, error) {
+	inspect, _, err := cli.ImageInspectWithRaw(ctx, image)
+	if client.IsErrNotFound(err) {
+		if IsDigestReference(image) {
+			// the pinned image isn't even present, so the container can't be running it
+			return false, nil
+		}
+		return cont.Image == image, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return inspect.ID == cont.ImageID, nil
+}
+
+// PullImage pulls image, joining a pull of the same image to the same host
+// if one is already running, so services sharing an image wake together.
+func (s *Server) PullImage(ctx context.Context, cli *client.Client, image string) error {
+	return s.PullFlights.Do(ctx, s.ctx, cli.DaemonHost()+" "+image, func(ctx context.Context) error {
+		resp, err := cli.ImagePull(ctx, image, types.ImagePullOptions{})
+		if err != nil {
+			return err
+		}
+		defer resp.Close()
+		io.Copy(os.Stdout, resp)
+		return nil
+	})
+}
+
+// ImageSummaryMatches reports whether a local image satisfies the configured reference.
+func ImageSummaryMatches(summary types.ImageSummary, image string) bool {
+	refs := summary.RepoTags
+	if IsDigestReference(image) {
+		refs = summary.RepoDigests
+	}
+	for _, ref := range refs {
+		if ref == image {
+			return true
+		}
+	}
+	return false
+}
+
+// WatchImageUpdates periodically pulls configured images and recreates
+// containers whose image moved on, or marks busy ones for recreation at next idle.
+func (s *Server) WatchImageUpdates(ctx context.Context) {
+	if s.Config.ImageUpdateInterval <= 0 {
+		return
+	}
+	for sleep(ctx, time.Duration(s.Config.ImageUpdateInterval)*time.Second) {
+		for _, app := range s.Instances() {
+			err := s.CheckImageUpdate(app)
+			if err != nil {
+				s.Logger.Error("Error checking image update", "service", app.Name, "err", err)
+			}
+		}
+	}
+}
+
+func (s *Server) CheckImageUpdate(app Service) (err error) {
+	if strings.ToLower(app.PullPolicy) == Never || IsDigestReference(app.Image) {
+		return
+	}
+
+	cli, err := s.Docker(app.Name)
+	if err != nil {
+		return
+	}
+
+	var resp io.ReadCloser
+	resp, err = cli.ImagePull(s.ctx, app.Image, types.ImagePullOptions{})
+	if err != nil {
+		return
+	}
+	io.Copy(io.Discard, resp)
+	resp.Close()
+
+	var cont *types.Container
+	cont, err = s.FindContainer(cli, app.Name)
+	if err != nil || cont == nil {
+		return
+	}
+	matchCtx, cancel := s.dockerContext(s.ctx)
+	var matches bool
+	matches, err = ContainerImageMatches(matchCtx, cli, cont, app.Image)
+	cancel()
+	if err != nil || matches {
+		return
+	}
+	s.Logger.Info("Image was updated", "service", app.Name, "image", app.Image)
+
+	busy := false
+	updating := false
+	blueGreen := strings.ToLower(app.UpdateStrategy) == UpdateBlueGreen
+	func() {
+		s.ServerLock.Lock()
+		defer s.ServerLock.Unlock()
+		if _, updating = s.ServiceSuccessors[app.Name]; updating {
+			return
+		}
+		if s.ConnCount(app.Name) > 0 {
+			busy = true
+			if blueGreen {
+				// claimed until the successor is ready to take connections
+				s.ServiceSuccessors[app.Name] = ""
+			} else {
+				s.ServiceRecreatePending[app.Name] = true
+			}
+		}
+	}()
+	if updating {
+		return
+	}
+	if busy && blueGreen {
+		go func() {
+			err := s.BlueGreenUpdate(app)
+			if err != nil {
+				s.Logger.Error("Error updating service", "service", app.Name, "err", err)
+			}
+		}()
+		return
+	}
+	if busy {
+		s.Logger.Info("Service is busy, recreating at next idle", "service", app.Name)
+		return
+	}
+
+	if cont.State == "running" || cont.State == "paused" {
+		var unlock func()
+		unlock, err = s.Cluster.Lock(s.ctx, app.Name)
+		if err != nil {
+			return
+		}
+		err = s.StopContainerWithAction(app.Name, IdleRemove, false)
+		unlock()
+		if err != nil {
+			return
+		}
+		s.AuditReason(AuditStop, app, "image update", "", nil)
+		s.ClearKillTime(app.Name)
+		return
+	}
+
+	func() {
+		s.ContainerAPILock.Lock(app.Name)
+		defer s.ContainerAPILock.Unlock(app.Name)
+		ctx, cancel := s.dockerContext(s.ctx)
+		defer cancel()
+		err = cli.ContainerRemove(ctx, cont.ID, types.ContainerRemoveOptions{})
+	}()
+	if err != nil {
+		return
+	}
+	s.ForgetPortMappings(app.Name)
+	s.Logger.Info("Removed outdated container", "service", app.Name, "container", cont.ID)
+	return
+}
+
+// TakeRecreatePending reports and clears whether a service should be recreated when it next goes idle.
+func (s *Server) TakeRecreatePending(name string) bool {
+	s.ServerLock.Lock()
+	defer s.ServerLock.Unlock()
+	pending := s.ServiceRecreatePending[name]
+	delete(s.ServiceRecreatePending, name)
+	return pending
+}
@@ -0,0 +1,200 @@
+package fishingboat
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWTAuth requires a bearer token signed by one of the keys at JWKSURL.
+type JWTAuth struct {
+	JWKSURL  string `json:"jwksURL"`
+	Issuer   string `json:"issuer,omitempty"`
+	Audience string `json:"audience,omitempty"`
+	// seconds between refreshes of the key set, defaults to 3600
+	RefreshInterval int `json:"refreshInterval,omitempty"`
+}
+
+// jwks caches a key set by key ID. Tokens signed with an unknown key trigger
+// a refresh, at most every 10 seconds.
+type jwks struct {
+	lock    sync.Mutex
+	keys    map[string]any
+	fetched time.Time
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// JWKS returns the cached key set at url, creating it if needed.
+func (s *Server) JWKS(url string) *jwks {
+	s.ServerLock.Lock()
+	defer s.ServerLock.Unlock()
+	keys, ok := s.KeySets[url]
+	if !ok {
+		keys = &jwks{}
+		s.KeySets[url] = keys
+	}
+	return keys
+}
+
+func (k *jwks) Key(config *JWTAuth, kid string) (any, error) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	refresh := time.Hour
+	if config.RefreshInterval > 0 {
+		refresh = time.Duration(config.RefreshInterval) * time.Second
+	}
+	_, known := k.keys[kid]
+	if time.Since(k.fetched) > refresh || (!known && time.Since(k.fetched) > 10*time.Second) {
+		keys, err := fetchJWKS(config.JWKSURL)
+		if err != nil {
+			return nil, err
+		}
+		k.keys = keys
+		k.fetched = time.Now()
+	}
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	return key, nil
+}
+
+func fetchJWKS(url string) (keys map[string]any, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS returned status %s", resp.Status)
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return
+	}
+	keys = make(map[string]any)
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			slog.Warn("Skipping JWKS key", "url", url, "kid", jwk.Kid, "err", err)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	return
+}
+
+func (jwk jsonWebKey) PublicKey() (any, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decode(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", jwk.Crv)
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %s", jwk.Crv)
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", jwk.Kty)
+}
+
+// ValidateJWT checks the request's bearer token, answering it with a 401 and
+// returning false if it isn't valid.
+func (s *Server) ValidateJWT(w http.ResponseWriter, r *http.Request, app Service) bool {
+	config := app.Auth.JWT
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(30 * time.Second),
+	}
+	if config.Issuer != "" {
+		options = append(options, jwt.WithIssuer(config.Issuer))
+	}
+	if config.Audience != "" {
+		options = append(options, jwt.WithAudience(config.Audience))
+	}
+	_, err := jwt.NewParser(options...).Parse(given, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		return s.JWKS(config.JWKSURL).Key(config, kid)
+	})
+	if err != nil {
+		s.Logger.Info("Rejected bearer token", "service", app.Name, "remote", r.RemoteAddr, "err", err)
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,131 @@
+package fishingboat
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+)
+
+// labels every managed container is created with, so they can be found
+// whatever they end up being called
+const (
+	ManagedLabel = "fishingboat.managed"
+	ServiceLabel = "fishingboat.service"
+)
+
+// ownershipLabels marks a container created for a service.
+func ownershipLabels(name string) map[string]string {
+	return map[string]string{
+		ManagedLabel: "true",
+		ServiceLabel: name,
+	}
+}
+
+func listManaged(ctx context.Context, cli *client.Client, name string) ([]types.Container, error) {
+	args := filters.NewArgs(filters.Arg("label", ManagedLabel+"=true"))
+	if name != "" {
+		args.Add("label", ServiceLabel+"="+name)
+	}
+	return cli.ContainerList(ctx, types.ContainerListOptions{All: true, Filters: args})
+}
+
+// slotNames are the container names of every instance fishingboat manages,
+// including blue/green successors, mapped to their service.
+func (s *Server) slotNames() map[string]string {
+	slots := make(map[string]string)
+	for _, app := range s.Instances() {
+		slots["/"+s.ContainerName(app.Name)] = app.Name
+		successor := app.Name + successorSuffix
+		slots["/"+s.ContainerName(successor)] = successor
+	}
+	return slots
+}
+
+// FindContainer returns the managed container for a service, or nil if there
+// is none. A container under the service's name wins; otherwise one labelled
+// for the service is used, unless it has since been renamed into another
+// service's place, like a taken pool member or a promoted successor.
+func (s *Server) FindContainer(cli *client.Client, name string) (cont *types.Container, err error) {
+	ctx, cancel := s.dockerContext(s.ctx)
+	defer cancel()
+	cont, err = findContainerNamed(ctx, cli, s.ContainerName(name))
+	if err != nil || cont != nil {
+		return
+	}
+	list, err := listManaged(ctx, cli, name)
+	if err != nil {
+		return
+	}
+	slots := s.slotNames()
+	for i := range list {
+		renamed := slices.ContainsFunc(list[i].Names, func(containerName string) bool {
+			owner, ok := slots[containerName]
+			return ok && owner != name
+		})
+		if !renamed {
+			return &list[i], nil
+		}
+	}
+	return
+}
+
+// RemoveOrphans removes stopped managed containers whose service is no
+// longer configured. Running ones are left for the operator.
+func (s *Server) RemoveOrphans() (err error) {
+	hosts := []string{""}
+	if len(s.Config.Nodes) > 0 {
+		hosts = hosts[:0]
+		for _, node := range s.Config.Nodes {
+			hosts = append(hosts, node.Host)
+		}
+	}
+	for _, host := range hosts {
+		err = s.removeOrphansOn(host)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (s *Server) removeOrphansOn(host string) (err error) {
+	cli, err := s.DockerHost(host)
+	if err != nil {
+		return
+	}
+	ctx, cancel := s.dockerContext(s.ctx)
+	defer cancel()
+	list, err := listManaged(ctx, cli, "")
+	if err != nil {
+		return
+	}
+	slots := s.slotNames()
+	known := make(map[string]bool)
+	for _, name := range slots {
+		known[name] = true
+	}
+	for _, cont := range list {
+		owned := known[cont.Labels[ServiceLabel]] || slices.ContainsFunc(cont.Names, func(containerName string) bool {
+			_, ok := slots[containerName]
+			return ok
+		})
+		if owned {
+			continue
+		}
+		logger := s.Logger.With("service", cont.Labels[ServiceLabel], "container", strings.TrimPrefix(cont.Names[0], "/"))
+		if cont.State == "running" || cont.State == "paused" {
+			logger.Warn("Leaving running container of unconfigured service")
+			continue
+		}
+		err = cli.ContainerRemove(ctx, cont.ID, types.ContainerRemoveOptions{})
+		if err != nil {
+			return
+		}
+		logger.Info("Removed container of unconfigured service")
+	}
+	return
+}
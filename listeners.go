@@ -0,0 +1,136 @@
+package fishingboat
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// ErrNoListeners is returned for disabling a service that isn't listening on
+// any port of its own, like a replica or a warm pool member.
+var ErrNoListeners = errors.New("service has no listeners")
+
+// OpenListeners listens on each of app's proxied host ports and starts
+// accepting on them, taking sockets passed by systemd from activated where
+// there is one for the port. Ports that needed privileges since dropped may
+// not bind again once closed.
+//
+// Listeners follow the config Start was given. Config is read without
+// locking all over the server, so changing a service's ports takes a
+// restart; only DisableService and EnableService close and reopen them
+// while running.
+func (s *Server) OpenListeners(app Service, activated map[int]net.Listener) (err error) {
+	s.ListenerLock.Lock()
+	defer s.ListenerLock.Unlock()
+	return s.openListeners(app, activated)
+}
+
+// openListeners is OpenListeners with ListenerLock held.
+func (s *Server) openListeners(app Service, activated map[int]net.Listener) (err error) {
+	if _, ok := s.ServiceListeners[app.Name]; ok {
+		return fmt.Errorf("service %s is already listening", app.Name)
+	}
+	type opened struct {
+		listener net.Listener
+		port     PortMapping
+	}
+	var listeners []opened
+	defer func() {
+		if err != nil {
+			for _, l := range listeners {
+				l.listener.Close()
+			}
+		}
+	}()
+	for _, port := range app.Ports {
+		if port.Direct() {
+			continue
+		}
+		for _, hostPort := range port.HostPorts {
+			listener, ok := activated[hostPort]
+			if ok {
+				delete(activated, hostPort)
+			} else {
+				listener, err = s.ListenerFactory("tcp", s.Config.ProxyIP+":"+fmt.Sprint(hostPort))
+				if err != nil {
+					s.Logger.Error("Error listening", "service", app.Name, "port", hostPort, "err", err)
+					return
+				}
+			}
+			s.Logger.Info("Listening", "service", app.Name, "port", hostPort, "systemd", ok)
+			listeners = append(listeners, opened{s.limitListener(listener), port})
+		}
+	}
+	s.ServiceListeners[app.Name] = make([]net.Listener, 0, len(listeners))
+	for _, l := range listeners {
+		s.ServiceListeners[app.Name] = append(s.ServiceListeners[app.Name], l.listener)
+		s.ExpectedListeners.Add(1)
+		go s.Listen(l.listener, app, l.port)
+	}
+	return
+}
+
+// CloseListeners stops accepting connections for a service. Connections
+// already proxied are left to finish.
+func (s *Server) CloseListeners(name string) {
+	s.ListenerLock.Lock()
+	defer s.ListenerLock.Unlock()
+	s.closeListeners(name)
+}
+
+// closeListeners is CloseListeners with ListenerLock held.
+func (s *Server) closeListeners(name string) {
+	for _, listener := range s.ServiceListeners[name] {
+		listener.Close()
+		s.ExpectedListeners.Add(-1)
+	}
+	delete(s.ServiceListeners, name)
+}
+
+// CloseAllListeners closes every service's listeners on shutdown.
+func (s *Server) CloseAllListeners() {
+	s.ListenerLock.Lock()
+	defer s.ListenerLock.Unlock()
+	for name := range s.ServiceListeners {
+		s.closeListeners(name)
+	}
+}
+
+// DisableService closes app's listeners until it is enabled again, so new
+// connections are refused without waking it. Its container and open
+// connections are left alone.
+func (s *Server) DisableService(app Service) error {
+	s.ListenerLock.Lock()
+	defer s.ListenerLock.Unlock()
+	if s.ServiceDisabled[app.Name] {
+		return nil
+	}
+	if len(s.ServiceListeners[app.Name]) == 0 {
+		return fmt.Errorf("%w: %s", ErrNoListeners, app.Name)
+	}
+	s.closeListeners(app.Name)
+	s.ServiceDisabled[app.Name] = true
+	return nil
+}
+
+// EnableService opens a disabled service's listeners again.
+func (s *Server) EnableService(app Service) (err error) {
+	s.ListenerLock.Lock()
+	defer s.ListenerLock.Unlock()
+	if !s.ServiceDisabled[app.Name] {
+		return
+	}
+	err = s.openListeners(app, nil)
+	if err != nil {
+		return
+	}
+	delete(s.ServiceDisabled, app.Name)
+	return
+}
+
+// Disabled reports whether a service's listeners were closed by DisableService.
+func (s *Server) Disabled(name string) bool {
+	s.ListenerLock.Lock()
+	defer s.ListenerLock.Unlock()
+	return s.ServiceDisabled[name]
+}
@@ -0,0 +1,107 @@
+package fishingboat
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	LogStderr   = "stderr"
+	LogSyslog   = "syslog"
+	LogJournald = "journald"
+)
+
+type LogFile struct {
+	Path string
+	// megabytes before rotating
+	MaxSize int
+	// days to keep rotated files, 0 keeps them regardless of age
+	MaxAge int
+	// rotated files to keep, 0 keeps them all
+	MaxBackups int
+	Compress   bool
+	// also rotate this often regardless of size, 0 disables
+	RotateEvery time.Duration
+}
+
+// where our own logs and streamed container output go
+var logOutput io.Writer = os.Stderr
+
+// SetupLogging installs the default structured logger, writing text or JSON
+// records at or above level to stderr, a rotated file if given, syslog, or
+// the systemd journal.
+func SetupLogging(level string, format string, file *LogFile, target string) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("unknown log level %s", level)
+	}
+	options := &slog.HandlerOptions{Level: l}
+	switch strings.ToLower(target) {
+	case LogStderr, None:
+	case LogSyslog:
+		// syslog stamps its own time
+		options.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		}
+		handler, err := NewSyslogHandler(func(out *syslogWriter) slog.Handler {
+			if strings.ToLower(format) == "json" {
+				return slog.NewJSONHandler(out, options)
+			}
+			return slog.NewTextHandler(out, options)
+		})
+		if err != nil {
+			return err
+		}
+		slog.SetDefault(slog.New(handler))
+		return nil
+	case LogJournald:
+		handler, err := NewJournalHandler(l)
+		if err != nil {
+			return err
+		}
+		slog.SetDefault(slog.New(handler))
+		return nil
+	default:
+		return fmt.Errorf("unknown log target %s", target)
+	}
+	if file != nil {
+		rotated := &lumberjack.Logger{
+			Filename:   file.Path,
+			MaxSize:    file.MaxSize,
+			MaxAge:     file.MaxAge,
+			MaxBackups: file.MaxBackups,
+			Compress:   file.Compress,
+			LocalTime:  true,
+		}
+		if file.RotateEvery > 0 {
+			go func() {
+				for range time.Tick(file.RotateEvery) {
+					if err := rotated.Rotate(); err != nil {
+						fmt.Fprintln(os.Stderr, "Error rotating log file:", err)
+					}
+				}
+			}()
+		}
+		logOutput = rotated
+	}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "text", None:
+		handler = slog.NewTextHandler(logOutput, options)
+	case "json":
+		handler = slog.NewJSONHandler(logOutput, options)
+	default:
+		return fmt.Errorf("unknown log format %s", format)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
@@ -0,0 +1,166 @@
+//go:build !windows
+
+package fishingboat
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"log/syslog"
+	"net"
+	"strings"
+	"sync"
+)
+
+const journalSocket = "/run/systemd/journal/socket"
+
+// syslogPriority maps slog levels onto syslog/journald priorities.
+func syslogPriority(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3
+	case level >= slog.LevelWarn:
+		return 4
+	case level >= slog.LevelInfo:
+		return 6
+	}
+	return 7
+}
+
+// syslogWriter sends each record, already formatted by a text or JSON
+// handler, to syslog at the priority of the record being handled.
+type syslogWriter struct {
+	lock   sync.Mutex
+	writer *syslog.Writer
+	level  slog.Level
+}
+
+func (w *syslogWriter) Write(p []byte) (int, error) {
+	message := string(bytes.TrimRight(p, "\n"))
+	var err error
+	switch syslogPriority(w.level) {
+	case 3:
+		err = w.writer.Err(message)
+	case 4:
+		err = w.writer.Warning(message)
+	case 6:
+		err = w.writer.Info(message)
+	default:
+		err = w.writer.Debug(message)
+	}
+	return len(p), err
+}
+
+type syslogHandler struct {
+	inner slog.Handler
+	out   *syslogWriter
+}
+
+func NewSyslogHandler(format func(*syslogWriter) slog.Handler) (slog.Handler, error) {
+	writer, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, "fishingboat")
+	if err != nil {
+		return nil, err
+	}
+	out := &syslogWriter{writer: writer}
+	return &syslogHandler{inner: format(out), out: out}, nil
+}
+
+func (h *syslogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *syslogHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.out.lock.Lock()
+	defer h.out.lock.Unlock()
+	h.out.level = r.Level
+	return h.inner.Handle(ctx, r)
+}
+
+func (h *syslogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &syslogHandler{inner: h.inner.WithAttrs(attrs), out: h.out}
+}
+
+func (h *syslogHandler) WithGroup(name string) slog.Handler {
+	return &syslogHandler{inner: h.inner.WithGroup(name), out: h.out}
+}
+
+// journalHandler writes records to the systemd journal's native protocol,
+// keeping attributes as separate fields so they can be filtered on, e.g.
+// journalctl SERVICE=minecraft.
+type journalHandler struct {
+	conn   *net.UnixConn
+	level  slog.Leveler
+	attrs  []slog.Attr
+	prefix string
+}
+
+func NewJournalHandler(level slog.Leveler) (slog.Handler, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &journalHandler{conn: conn, level: level}, nil
+}
+
+func (h *journalHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *journalHandler) Handle(_ context.Context, r slog.Record) error {
+	var buf bytes.Buffer
+	writeJournalField(&buf, "MESSAGE", r.Message)
+	writeJournalField(&buf, "PRIORITY", fmt.Sprint(syslogPriority(r.Level)))
+	writeJournalField(&buf, "SYSLOG_IDENTIFIER", "fishingboat")
+	for _, attr := range h.attrs {
+		writeJournalField(&buf, attr.Key, attr.Value.String())
+	}
+	r.Attrs(func(attr slog.Attr) bool {
+		writeJournalField(&buf, h.prefix+attr.Key, attr.Value.String())
+		return true
+	})
+	_, err := h.conn.Write(buf.Bytes())
+	return err
+}
+
+func (h *journalHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handler := *h
+	handler.attrs = append([]slog.Attr(nil), h.attrs...)
+	for _, attr := range attrs {
+		handler.attrs = append(handler.attrs, slog.Attr{Key: h.prefix + attr.Key, Value: attr.Value})
+	}
+	return &handler
+}
+
+func (h *journalHandler) WithGroup(name string) slog.Handler {
+	handler := *h
+	handler.prefix = h.prefix + name + "_"
+	return &handler
+}
+
+// writeJournalField appends one field, using the binary form for values
+// containing newlines. Keys may only hold uppercase letters, digits, and
+// underscores.
+func writeJournalField(buf *bytes.Buffer, key string, value string) {
+	key = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		}
+		return '_'
+	}, key)
+	key = strings.TrimLeft(key, "_")
+	if key == "" {
+		return
+	}
+	if !strings.Contains(value, "\n") {
+		buf.WriteString(key + "=" + value + "\n")
+		return
+	}
+	buf.WriteString(key + "\n")
+	binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value + "\n")
+}
@@ -0,0 +1,22 @@
+package fishingboat
+
+import (
+	"errors"
+	"log/slog"
+)
+
+// syslogWriter only exists so SetupLogging builds; there is no syslog or
+// journal on Windows. Log to a file with --log-file instead.
+type syslogWriter struct{}
+
+func (*syslogWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func NewSyslogHandler(format func(*syslogWriter) slog.Handler) (slog.Handler, error) {
+	return nil, errors.New("syslog is not available on Windows")
+}
+
+func NewJournalHandler(level slog.Leveler) (slog.Handler, error) {
+	return nil, errors.New("journald is not available on Windows")
+}
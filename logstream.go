@@ -0,0 +1,181 @@
+package fishingboat
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// ContainerLogs streams a managed container's output while it runs.
+type ContainerLogs struct {
+	// file to append to, or our own log output prefixed by the service name if empty
+	Path string `json:"path,omitempty"`
+}
+
+// LogDriver is where Docker keeps a container's output, e.g. json-file with
+// a max-size so it can't fill the disk, or loki or journald to land in a log
+// pipeline.
+type LogDriver struct {
+	Driver string `json:"driver"`
+	// driver options, e.g. {"max-size": "10m", "max-file": "3"}
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// Apply sets the log driver on a container's host config.
+func (d *LogDriver) Apply(hostConfig *container.HostConfig) {
+	if d == nil {
+		return
+	}
+	hostConfig.LogConfig = container.LogConfig{Type: d.Driver, Config: d.Options}
+}
+
+// serializes lines from every service written to fishingboat's output
+var containerOutputLock sync.Mutex
+
+// StreamLogs follows a started container's stdout and stderr until it stops.
+func (s *Server) StreamLogs(app Service, contID string, since time.Time) {
+	err := func() (err error) {
+		cli, err := s.Docker(app.Name)
+		if err != nil {
+			return
+		}
+
+		var out io.Writer
+		if app.Logs.Path != "" {
+			var f *os.File
+			f, err = os.OpenFile(app.Logs.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+			if err != nil {
+				return
+			}
+			defer f.Close()
+			out = f
+		} else {
+			out = prefixWriter{prefix: "[" + app.Name + "] ", w: logOutput}
+		}
+
+		var inspect types.ContainerJSON
+		inspectCtx, cancel := s.dockerContext(s.ctx)
+		inspect, err = cli.ContainerInspect(inspectCtx, contID)
+		cancel()
+		if err != nil {
+			return
+		}
+		var logs io.ReadCloser
+		logs, err = cli.ContainerLogs(s.ctx, contID, types.ContainerLogsOptions{
+			ShowStdout: true,
+			ShowStderr: true,
+			Follow:     true,
+			Since:      strconv.FormatInt(since.Unix(), 10),
+		})
+		if err != nil {
+			return
+		}
+		defer logs.Close()
+		if inspect.Config.Tty {
+			_, err = io.Copy(out, logs)
+		} else {
+			_, err = stdcopy.StdCopy(out, out, logs)
+		}
+		return
+	}()
+	if err != nil && s.ctx.Err() == nil {
+		s.Logger.Error("Error streaming container logs", "service", app.Name, "err", err)
+	}
+}
+
+// prefixWriter writes each complete line with a prefix so concurrent
+// services don't interleave mid-line.
+type prefixWriter struct {
+	prefix string
+	w      io.Writer
+}
+
+func (p prefixWriter) Write(buf []byte) (int, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	containerOutputLock.Lock()
+	defer containerOutputLock.Unlock()
+	for scanner.Scan() {
+		if _, err := fmt.Fprintln(p.w, p.prefix+scanner.Text()); err != nil {
+			return 0, err
+		}
+	}
+	return len(buf), nil
+}
+
+// flushWriter sends each write on to the client straight away.
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (f flushWriter) Write(p []byte) (n int, err error) {
+	n, err = f.w.Write(p)
+	if flusher, ok := f.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return
+}
+
+// HandleLogs writes a service's container output, the last tail lines of it
+// (default all) and then new lines as they come if follow is set.
+func (s *Server) HandleLogs(w http.ResponseWriter, r *http.Request, app Service) {
+	err := func() (err error) {
+		cli, err := s.Docker(app.Name)
+		if err != nil {
+			return
+		}
+		var cont *types.Container
+		cont, err = s.FindContainer(cli, app.Name)
+		if err != nil {
+			return
+		}
+		if cont == nil {
+			return ErrNoContainer
+		}
+		var inspect types.ContainerJSON
+		inspect, err = cli.ContainerInspect(r.Context(), cont.ID)
+		if err != nil {
+			return
+		}
+		tail := r.URL.Query().Get("tail")
+		if tail == "" {
+			tail = "all"
+		}
+		follow, _ := strconv.ParseBool(r.URL.Query().Get("follow"))
+		var logs io.ReadCloser
+		logs, err = cli.ContainerLogs(r.Context(), cont.ID, types.ContainerLogsOptions{
+			ShowStdout: true,
+			ShowStderr: true,
+			Follow:     follow,
+			Tail:       tail,
+		})
+		if err != nil {
+			return
+		}
+		defer logs.Close()
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		out := flushWriter{w: w}
+		if inspect.Config.Tty {
+			io.Copy(out, logs)
+		} else {
+			stdcopy.StdCopy(out, out, logs)
+		}
+		return nil
+	}()
+	if errors.Is(err, ErrNoContainer) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+	} else if err != nil {
+		s.Logger.Error("Error reading container logs", "service", app.Name, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,100 @@
+package fishingboat
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/docker/api/types/mount"
+)
+
+// Mount is a volume or host path mounted into a service's container, so
+// hostConfig.binds doesn't have to be written by hand.
+type Mount struct {
+	// bind (default), volume, or tmpfs
+	Type string `json:"type,omitempty"`
+	// host path for binds, relative to the working directory, or the volume
+	// name. Unused for tmpfs.
+	Source   string `json:"source,omitempty"`
+	Target   string `json:"target"`
+	ReadOnly bool   `json:"readOnly,omitempty"`
+	// make a missing bind source directory instead of failing the launch
+	Create bool `json:"create,omitempty"`
+}
+
+func (m *Mount) kind() mount.Type {
+	if m.Type == "" {
+		return mount.TypeBind
+	}
+	return mount.Type(m.Type)
+}
+
+// ValidateMounts checks every service's mounts are well formed before any
+// container is touched. Whether bind sources exist is checked at launch.
+func (s *Server) ValidateMounts() error {
+	for _, app := range s.Config.Services {
+		for _, m := range app.Mounts {
+			if m.Target == "" {
+				return fmt.Errorf("service %s: mount of %s needs a target", app.Name, m.Source)
+			}
+			switch m.kind() {
+			case mount.TypeBind, mount.TypeVolume:
+				if m.Source == "" {
+					return fmt.Errorf("service %s: %s mount at %s needs a source", app.Name, m.kind(), m.Target)
+				}
+			case mount.TypeTmpfs:
+				if m.Source != "" {
+					return fmt.Errorf("service %s: tmpfs mount at %s can't have a source", app.Name, m.Target)
+				}
+			default:
+				return fmt.Errorf("service %s: unknown mount type %s", app.Name, m.Type)
+			}
+		}
+	}
+	return nil
+}
+
+// dockerMounts converts a service's mounts for its create spec.
+func dockerMounts(app Service) (mounts []mount.Mount, err error) {
+	for _, m := range app.Mounts {
+		source := m.Source
+		if m.kind() == mount.TypeBind {
+			source, err = filepath.Abs(source)
+			if err != nil {
+				return
+			}
+		}
+		mounts = append(mounts, mount.Mount{
+			Type:     m.kind(),
+			Source:   source,
+			Target:   m.Target,
+			ReadOnly: m.ReadOnly,
+		})
+	}
+	return
+}
+
+// PrepareMounts makes sure app's bind sources exist, creating the ones it is
+// allowed to. Services on other Docker nodes are left for their daemon to
+// complain about.
+func (s *Server) PrepareMounts(app Service) (err error) {
+	if s.NodeOf(app.Name) != nil {
+		return
+	}
+	for _, m := range app.Mounts {
+		if m.kind() != mount.TypeBind {
+			continue
+		}
+		_, err = os.Stat(m.Source)
+		if os.IsNotExist(err) && m.Create {
+			err = os.MkdirAll(m.Source, 0o755)
+			if err == nil {
+				s.Logger.Info("Created mount source", "service", app.Name, "source", m.Source)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("mount source for %s: %w", m.Target, err)
+		}
+	}
+	return
+}
@@ -0,0 +1,195 @@
+package fishingboat
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// legacySuffix is what managed containers were always named with before
+// naming was configurable.
+const legacySuffix = "-goscalezero"
+
+// NamingConfig decides what managed containers are called in Docker. Without
+// it containers keep the old <service>-goscalezero names.
+type NamingConfig struct {
+	Prefix string `json:"prefix,omitempty"`
+	Suffix string `json:"suffix,omitempty"`
+	// text/template over {{.Name}}, e.g. "fb-{{.Name}}", instead of the
+	// prefix and suffix
+	Template string `json:"template,omitempty"`
+	// templates of earlier naming schemes whose containers are renamed at
+	// startup. The old -goscalezero names are always migrated.
+	MigrateFrom []string `json:"migrateFrom,omitempty"`
+}
+
+// containerNaming is NamingConfig with its templates parsed.
+type containerNaming struct {
+	template    *template.Template
+	migrateFrom []*template.Template
+}
+
+type nameData struct {
+	Name string
+}
+
+func parseNameTemplate(text string) (*template.Template, error) {
+	return template.New("name").Option("missingkey=error").Parse(text)
+}
+
+func renderName(tmpl *template.Template, name string) (string, error) {
+	var out strings.Builder
+	err := tmpl.Execute(&out, nameData{Name: name})
+	return out.String(), err
+}
+
+// ValidateNaming parses the naming templates when the config is loaded,
+// before any container is touched, and keeps them for ContainerName.
+func (s *Server) ValidateNaming() (err error) {
+	naming := s.Config.Naming
+	if naming == nil {
+		return
+	}
+	parse := func(text string) (tmpl *template.Template, err error) {
+		tmpl, err = parseNameTemplate(text)
+		if err == nil {
+			var name string
+			name, err = renderName(tmpl, "service")
+			if err == nil && name == "" {
+				err = fmt.Errorf("renders an empty name")
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("container name template %q: %w", text, err)
+		}
+		return
+	}
+	var parsed containerNaming
+	if naming.Template != "" {
+		parsed.template, err = parse(naming.Template)
+		if err != nil {
+			return
+		}
+	}
+	for _, text := range naming.MigrateFrom {
+		if text == "" {
+			continue
+		}
+		var tmpl *template.Template
+		tmpl, err = parse(text)
+		if err != nil {
+			return
+		}
+		parsed.migrateFrom = append(parsed.migrateFrom, tmpl)
+	}
+	if naming.Template == "" && naming.Prefix == "" && naming.Suffix == "" {
+		return fmt.Errorf("naming needs a prefix, suffix, or template, or containers would share their service's name")
+	}
+	s.naming = parsed
+	return
+}
+
+// ContainerName is the Docker name of a service's managed container.
+func (s *Server) ContainerName(name string) string {
+	naming := s.Config.Naming
+	if naming == nil {
+		return name + legacySuffix
+	}
+	if s.naming.template != nil {
+		rendered, err := renderName(s.naming.template, name)
+		if err == nil {
+			return rendered
+		}
+		// ValidateNaming tried the template when the config was loaded
+		s.Logger.Error("Error rendering container name", "service", name, "err", err)
+	}
+	return naming.Prefix + name + naming.Suffix
+}
+
+// previousNames are the names a service's container may have been created
+// under by earlier naming schemes.
+func (s *Server) previousNames(name string) (names []string) {
+	current := s.ContainerName(name)
+	add := func(old string) {
+		if old != "" && old != current {
+			names = append(names, old)
+		}
+	}
+	add(name + legacySuffix)
+	if s.Config.Naming == nil {
+		return
+	}
+	for _, tmpl := range s.naming.migrateFrom {
+		old, err := renderName(tmpl, name)
+		if err == nil {
+			add(old)
+		}
+	}
+	return
+}
+
+// MigrateContainerNames renames containers left under an earlier naming
+// scheme so they are adopted instead of duplicated. Containers already under
+// their new name are left alone.
+func (s *Server) MigrateContainerNames() (err error) {
+	hosts := []string{""}
+	if len(s.Config.Nodes) > 0 {
+		hosts = hosts[:0]
+		for _, node := range s.Config.Nodes {
+			hosts = append(hosts, node.Host)
+		}
+	}
+	for _, host := range hosts {
+		err = s.migrateNamesOn(host)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (s *Server) migrateNamesOn(host string) (err error) {
+	cli, err := s.DockerHost(host)
+	if err != nil {
+		return
+	}
+	for _, app := range s.Instances() {
+		err = s.migrateName(cli, app)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// migrateName renames app's container on cli from the first earlier name it
+// is found under, each with its own Docker timeout.
+func (s *Server) migrateName(cli *client.Client, app Service) (err error) {
+	ctx, cancel := s.dockerContext(s.ctx)
+	defer cancel()
+	name := s.ContainerName(app.Name)
+	existing, err := findContainerNamed(ctx, cli, name)
+	if err != nil || existing != nil {
+		return
+	}
+	for _, old := range s.previousNames(app.Name) {
+		var cont *types.Container
+		cont, err = findContainerNamed(ctx, cli, old)
+		if err != nil {
+			return
+		}
+		if cont == nil {
+			continue
+		}
+		err = cli.ContainerRename(ctx, cont.ID, name)
+		if err != nil {
+			return
+		}
+		s.Logger.Info("Renamed container to the configured naming", "service", app.Name, "from", old, "to", name)
+		return
+	}
+	return
+}
@@ -0,0 +1,118 @@
+package fishingboat
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+)
+
+// NetworkAttachment connects a service's container to a Docker network, e.g.
+// a compose stack's default network so it can reach the stack's services.
+type NetworkAttachment struct {
+	Name string `json:"name"`
+	// extra DNS names other containers on the network can use
+	Aliases []string `json:"aliases,omitempty"`
+	// static addresses, which need a network with a configured subnet and
+	// only suit services without replicas, a warm pool, or blue/green updates
+	IPv4Address string `json:"ipv4Address,omitempty"`
+	IPv6Address string `json:"ipv6Address,omitempty"`
+	// how to create the network if it doesn't exist yet, otherwise a missing
+	// network fails the launch
+	Create *NetworkCreate `json:"create,omitempty"`
+}
+
+type NetworkCreate struct {
+	// defaults to bridge
+	Driver string `json:"driver,omitempty"`
+	// e.g. 172.28.0.0/16, needed for static addresses
+	Subnet   string `json:"subnet,omitempty"`
+	Gateway  string `json:"gateway,omitempty"`
+	Internal bool   `json:"internal,omitempty"`
+}
+
+func (n *NetworkAttachment) endpoint() *network.EndpointSettings {
+	endpoint := &network.EndpointSettings{Aliases: n.Aliases}
+	if n.IPv4Address != "" || n.IPv6Address != "" {
+		endpoint.IPAMConfig = &network.EndpointIPAMConfig{
+			IPv4Address: n.IPv4Address,
+			IPv6Address: n.IPv6Address,
+		}
+	}
+	return endpoint
+}
+
+// NetworkingConfig is the network a container is created on. Docker only
+// takes one at create time, the rest are connected by ConnectNetworks.
+func NetworkingConfig(app Service) *network.NetworkingConfig {
+	if len(app.Networks) == 0 {
+		return nil
+	}
+	first := &app.Networks[0]
+	return &network.NetworkingConfig{
+		EndpointsConfig: map[string]*network.EndpointSettings{first.Name: first.endpoint()},
+	}
+}
+
+// EnsureNetworks creates any of app's networks that are missing and asked to
+// be created.
+func (s *Server) EnsureNetworks(ctx context.Context, cli *client.Client, app Service) (err error) {
+	ctx, cancel := s.dockerContext(ctx)
+	defer cancel()
+	for _, attachment := range app.Networks {
+		_, err = cli.NetworkInspect(ctx, attachment.Name, types.NetworkInspectOptions{})
+		if err == nil {
+			continue
+		}
+		if !client.IsErrNotFound(err) {
+			return
+		}
+		if attachment.Create == nil {
+			return fmt.Errorf("network %s does not exist", attachment.Name)
+		}
+		options := types.NetworkCreate{
+			CheckDuplicate: true,
+			Driver:         attachment.Create.Driver,
+			Internal:       attachment.Create.Internal,
+			Labels:         map[string]string{ManagedLabel: "true"},
+		}
+		if options.Driver == "" {
+			options.Driver = "bridge"
+		}
+		if attachment.Create.Subnet != "" {
+			options.IPAM = &network.IPAM{Config: []network.IPAMConfig{{
+				Subnet:  attachment.Create.Subnet,
+				Gateway: attachment.Create.Gateway,
+			}}}
+		}
+		_, err = cli.NetworkCreate(ctx, attachment.Name, options)
+		if err != nil {
+			// another service may have just created it
+			_, inspectErr := cli.NetworkInspect(ctx, attachment.Name, types.NetworkInspectOptions{})
+			if inspectErr != nil {
+				return
+			}
+			err = nil
+			continue
+		}
+		s.Logger.Info("Created network", "service", app.Name, "network", attachment.Name)
+	}
+	return
+}
+
+// ConnectNetworks attaches a newly created container to the networks after
+// the first.
+func (s *Server) ConnectNetworks(ctx context.Context, cli *client.Client, app Service, contID string) (err error) {
+	ctx, cancel := s.dockerContext(ctx)
+	defer cancel()
+	for i := 1; i < len(app.Networks); i++ {
+		attachment := &app.Networks[i]
+		err = cli.NetworkConnect(ctx, attachment.Name, contID, attachment.endpoint())
+		if err != nil {
+			return fmt.Errorf("connecting to network %s: %w", attachment.Name, err)
+		}
+	}
+	return
+}
@@ -0,0 +1,238 @@
+package fishingboat
+
+import (
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// DockerNode is a Docker host services can be placed on. With nodes
+// configured, every service runs on one of them instead of the local daemon.
+// Services are only moved between nodes while stopped, see Migrate.
+type DockerNode struct {
+	Name string `json:"name"`
+	// Docker API address, e.g. tcp://10.0.0.2:2376 or a Windows host's
+	// npipe:////./pipe/docker_engine. TLS settings come from the environment
+	// as for the local daemon.
+	Host string `json:"host"`
+	// address the proxy reaches published container ports on
+	IP     string    `json:"ip"`
+	Limits Resources `json:"allocationLimits"`
+}
+
+func (s *Server) FindNode(name string) *DockerNode {
+	for i := range s.Config.Nodes {
+		if s.Config.Nodes[i].Name == name {
+			return &s.Config.Nodes[i]
+		}
+	}
+	return nil
+}
+
+// NodeOf returns the node a service is placed on, or nil if it runs locally
+// or hasn't been placed yet.
+func (s *Server) NodeOf(name string) *DockerNode {
+	s.TrackedResourcesLock.RLock()
+	defer s.TrackedResourcesLock.RUnlock()
+	return s.FindNode(s.ServiceNodes[name])
+}
+
+// Docker connects to the daemon hosting a service's container.
+func (s *Server) Docker(name string) (*client.Client, error) {
+	if node := s.NodeOf(name); node != nil {
+		return s.DockerHost(node.Host)
+	}
+	return s.DockerHost("")
+}
+
+// placementBase is the service whose node a derived container has to share,
+// so blue/green successors and pool members can be renamed into place.
+func (s *Server) placementBase(name string) string {
+	if s.findSuccessor(name) != nil {
+		return strings.TrimSuffix(name, successorSuffix)
+	}
+	if s.findPoolMember(name) != nil {
+		return name[:strings.LastIndex(name, "-pool-")]
+	}
+	return name
+}
+
+// LocateService looks for an existing container for app on each node and
+// records its placement if one is found.
+func (s *Server) LocateService(app Service) (err error) {
+	if len(s.Config.Nodes) == 0 || s.NodeOf(app.Name) != nil {
+		return
+	}
+	for _, node := range s.Config.Nodes {
+		var cli *client.Client
+		var cont *types.Container
+		cli, err = s.DockerHost(node.Host)
+		if err == nil {
+			cont, err = s.FindContainer(cli, app.Name)
+		}
+		if err != nil {
+			s.Logger.Warn("Error listing containers on node", "node", node.Name, "err", err)
+			err = nil
+			continue
+		}
+		if cont != nil {
+			s.placeOn(app.Name, node.Name)
+			return
+		}
+	}
+	return
+}
+
+// PlaceService picks a node for app if it doesn't have one: wherever another
+// fishingboat placed it, wherever its container already is, or else the node
+// with the most room left.
+func (s *Server) PlaceService(app Service) (err error) {
+	if len(s.Config.Nodes) == 0 || s.NodeOf(app.Name) != nil {
+		return
+	}
+	if base := s.placementBase(app.Name); base != app.Name {
+		err = s.PlaceService(*s.FindService(base))
+		if err == nil {
+			s.placeOn(app.Name, s.NodeOf(base).Name)
+		}
+		return
+	}
+	if node := s.RemotePlacement(app.Name); s.FindNode(node) != nil {
+		s.placeOn(app.Name, node)
+		return
+	}
+	err = s.LocateService(app)
+	if err != nil || s.NodeOf(app.Name) != nil {
+		return
+	}
+	// stay with the warm pool so it can be taken
+	for _, member := range s.PoolMembers(app) {
+		if node := s.NodeOf(member.Name); node != nil {
+			s.placeOn(app.Name, node.Name)
+			return
+		}
+	}
+	node := s.pickNode(app, "")
+	if node == nil {
+		// nothing fits right now, reserving will fail or wait on the roomiest
+		node = s.roomiestNode()
+	}
+	s.placeOn(app.Name, node.Name)
+	s.Logger.Info("Placed service", "service", app.Name, "node", node.Name)
+	return
+}
+
+func (s *Server) placeOn(name string, node string) {
+	s.TrackedResourcesLock.Lock()
+	defer s.TrackedResourcesLock.Unlock()
+	s.ServiceNodes[name] = node
+}
+
+// Unplace forgets a removed service's node so its next container goes
+// wherever there is room.
+func (s *Server) Unplace(name string) {
+	s.TrackedResourcesLock.Lock()
+	defer s.TrackedResourcesLock.Unlock()
+	delete(s.ServiceNodes, name)
+}
+
+// nodeHeadroom is what is left of a node's scheduling limits.
+// TrackedResourcesLock must be held.
+func (s *Server) nodeHeadroom(node *DockerNode) (free Resources) {
+	limits := s.overcommitted(node.Limits)
+	allocated := s.NodeAllocated[node.Name]
+	free.MilliCPU = limits.MilliCPU - allocated.MilliCPU
+	free.MemoryMi = limits.MemoryMi - allocated.MemoryMi
+	free.GpuMemoryMi = limits.GpuMemoryMi - allocated.GpuMemoryMi
+	return
+}
+
+func fits(request *Resources, free Resources) bool {
+	if request == nil {
+		return true
+	}
+	return request.MilliCPU <= free.MilliCPU && request.MemoryMi <= free.MemoryMi && request.GpuMemoryMi <= free.GpuMemoryMi
+}
+
+// pickNode returns the node with the most free memory that fits app, other
+// than exclude, or nil if none do.
+func (s *Server) pickNode(app Service, exclude string) (best *DockerNode) {
+	s.TrackedResourcesLock.RLock()
+	defer s.TrackedResourcesLock.RUnlock()
+	var bestFree Resources
+	for i := range s.Config.Nodes {
+		node := &s.Config.Nodes[i]
+		if node.Name == exclude {
+			continue
+		}
+		free := s.nodeHeadroom(node)
+		if !fits(app.ResourceRequest, free) {
+			continue
+		}
+		if best == nil || free.MemoryMi > bestFree.MemoryMi {
+			best, bestFree = node, free
+		}
+	}
+	return
+}
+
+func (s *Server) roomiestNode() (best *DockerNode) {
+	s.TrackedResourcesLock.RLock()
+	defer s.TrackedResourcesLock.RUnlock()
+	var bestFree Resources
+	for i := range s.Config.Nodes {
+		node := &s.Config.Nodes[i]
+		free := s.nodeHeadroom(node)
+		if best == nil || free.MemoryMi > bestFree.MemoryMi {
+			best, bestFree = node, free
+		}
+	}
+	return
+}
+
+// Migrate moves a stopped container off a node that no longer has room for
+// it to one that does, by removing it so the next launch recreates it there.
+// It reports whether the service moved. Running and paused containers are
+// never moved, a busy node only sheds them as they scale down and get placed
+// again on their next wake. The caller must hold app's ContainerAPILock.
+func (s *Server) Migrate(cli *client.Client, app Service, cont *types.Container) (moved bool, err error) {
+	current := s.NodeOf(app.Name)
+	if current == nil || cont.State == "running" || cont.State == "paused" {
+		return
+	}
+	if s.placementBase(app.Name) != app.Name {
+		// derived containers stay with their service
+		return
+	}
+	roomy := func() bool {
+		s.TrackedResourcesLock.RLock()
+		defer s.TrackedResourcesLock.RUnlock()
+		return fits(app.ResourceRequest, s.nodeHeadroom(current))
+	}()
+	if roomy {
+		return
+	}
+	target := s.pickNode(app, current.Name)
+	if target == nil {
+		return
+	}
+	err = s.RemoveContainer(cli, app, cont)
+	if err != nil {
+		return
+	}
+	s.placeOn(app.Name, target.Name)
+	s.Logger.Info("Migrated stopped service", "service", app.Name, "from", current.Name, "to", target.Name)
+	return true, nil
+}
+
+// Placements copies which node each service is on.
+func (s *Server) Placements() map[string]string {
+	s.TrackedResourcesLock.RLock()
+	defer s.TrackedResourcesLock.RUnlock()
+	placements := make(map[string]string)
+	for name, node := range s.ServiceNodes {
+		placements[name] = node
+	}
+	return placements
+}
@@ -0,0 +1,93 @@
+package fishingboat
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	NotifyDiscord  = "discord"
+	NotifySlack    = "slack"
+	NotifyTelegram = "telegram"
+)
+
+// Notifier posts chat messages about a service's lifecycle.
+type Notifier struct {
+	Type string `json:"type"`
+	// incoming webhook URL for discord and slack
+	URL string `json:"url,omitempty"`
+	// bot token and chat for telegram
+	Token  string `json:"token,omitempty"`
+	ChatID string `json:"chatId,omitempty"`
+	// started, stopped, launchFailed, resourceRejected, or all if empty
+	Events []string `json:"events,omitempty"`
+	// seconds between messages for the same event, defaults to 60
+	MinInterval int `json:"minInterval,omitempty"`
+}
+
+func NotifyMessage(e Event) string {
+	switch e.Event {
+	case EventStarted:
+		return fmt.Sprintf("%s is up", e.Service)
+	case EventStopped:
+		return fmt.Sprintf("%s scaled to zero", e.Service)
+	case EventLaunchFailed, EventResourceRejected:
+		return fmt.Sprintf("%s failed to start: %s", e.Service, e.Error)
+	}
+	return fmt.Sprintf("%s: %s", e.Service, e.Event)
+}
+
+// Notify sends e to app's notifiers that want it and haven't sent it too recently.
+func (s *Server) Notify(app Service, e Event) {
+	for i, notifier := range app.Notify {
+		if !wantsEvent(notifier.Events, e.Event) {
+			continue
+		}
+		interval := 60 * time.Second
+		if notifier.MinInterval > 0 {
+			interval = time.Duration(notifier.MinInterval) * time.Second
+		}
+		key := fmt.Sprintf("%s/%d/%s", app.Name, i, e.Event)
+		limited := func() bool {
+			s.ServerLock.Lock()
+			defer s.ServerLock.Unlock()
+			if time.Since(s.NotifyLastSent[key]) < interval {
+				return true
+			}
+			s.NotifyLastSent[key] = time.Now()
+			return false
+		}()
+		if limited {
+			continue
+		}
+		go func(notifier Notifier) {
+			err := notifier.Send(NotifyMessage(e))
+			if err != nil {
+				s.Logger.Error("Error sending notification", "service", app.Name, "type", notifier.Type, "err", err)
+			}
+		}(notifier)
+	}
+}
+
+func (n Notifier) Send(message string) (err error) {
+	var url string
+	var payload any
+	switch strings.ToLower(n.Type) {
+	case NotifyDiscord:
+		url, payload = n.URL, map[string]string{"content": message}
+	case NotifySlack:
+		url, payload = n.URL, map[string]string{"text": message}
+	case NotifyTelegram:
+		url = "https://api.telegram.org/bot" + n.Token + "/sendMessage"
+		payload = map[string]string{"chat_id": n.ChatID, "text": message}
+	default:
+		return fmt.Errorf("unknown notifier type %s", n.Type)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	return PostJSON(url, body, 10*time.Second)
+}
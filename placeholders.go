@@ -0,0 +1,101 @@
+package fishingboat
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"text/template"
+)
+
+// specVars are what {{...}} placeholders in a service's cmd and env can
+// refer to, for images that need to be told how clients reach them.
+type specVars struct {
+	// the service's name, or the replica's. Pool containers get their
+	// service's, since they are taken over by it as they are.
+	ServiceName string
+	// the address fishingboat listens on for the service
+	HostIP string
+	ports  []PortMapping
+}
+
+// HostPort is the first port clients connect to for a container port.
+func (v specVars) HostPort(containerPort int) (int, error) {
+	for _, port := range v.ports {
+		if port.ContainerPort == containerPort && len(port.HostPorts) > 0 {
+			return port.HostPorts[0], nil
+		}
+	}
+	return 0, fmt.Errorf("no host port for container port %d", containerPort)
+}
+
+func (s *Server) specVars(app Service) specVars {
+	name := app.Name
+	if owner, _ := s.poolOwner(app.Name); owner != nil {
+		name = owner.Name
+	}
+	return specVars{ServiceName: name, HostIP: s.advertisedIP(), ports: app.Ports}
+}
+
+// advertisedIP is the address clients reach the proxy at, or "" when it
+// only listens on a wildcard and nobody said which address that is.
+func (s *Server) advertisedIP() string {
+	ip := s.Config.AdvertiseIP
+	if ip == "" {
+		ip = s.Config.ProxyIP
+	}
+	if ip == "" || net.ParseIP(ip).IsUnspecified() {
+		return ""
+	}
+	return ip
+}
+
+// ValidatePlaceholders makes sure {{.HostIP}} has a real address to expand
+// to, since a wildcard like 0.0.0.0 is no use to a client.
+func (s *Server) ValidatePlaceholders() error {
+	if s.Config.AdvertiseIP != "" && s.advertisedIP() == "" {
+		return fmt.Errorf("advertiseIP %s is a wildcard", s.Config.AdvertiseIP)
+	}
+	if s.advertisedIP() != "" {
+		return nil
+	}
+	for _, app := range s.Config.Services {
+		env, err := ContainerEnv(app)
+		if err != nil {
+			return fmt.Errorf("service %s: %w", app.Name, err)
+		}
+		for _, value := range append(append([]string(nil), app.Cmd...), env...) {
+			if strings.Contains(value, ".HostIP") {
+				return fmt.Errorf("service %s uses {{.HostIP}} but the proxy listens on a wildcard, set advertiseIP", app.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// expandPlaceholders renders each string that has placeholders, like
+// {{.HostPort 25565}}, leaving the rest as they are.
+func expandPlaceholders(values []string, vars specVars) (expanded []string, err error) {
+	for i, value := range values {
+		if !strings.Contains(value, "{{") {
+			continue
+		}
+		if expanded == nil {
+			expanded = append([]string(nil), values...)
+		}
+		var tmpl *template.Template
+		tmpl, err = template.New("").Option("missingkey=error").Parse(value)
+		if err != nil {
+			return nil, err
+		}
+		var out strings.Builder
+		err = tmpl.Execute(&out, vars)
+		if err != nil {
+			return nil, err
+		}
+		expanded[i] = out.String()
+	}
+	if expanded == nil {
+		expanded = values
+	}
+	return
+}
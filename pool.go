@@ -0,0 +1,145 @@
+package fishingboat
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// PoolService derives slot i of app's warm pool. Pool containers are started
+// once and then paused or stopped, so a wake only has to unpause or start one.
+func PoolService(app Service, i int) Service {
+	member := app
+	member.Name = fmt.Sprintf("%s-pool-%d", app.Name, i)
+	member.Group = ""
+	member.Schedules = nil
+	member.MaxReplicas = 0
+	member.WarmPool = 0
+	return member
+}
+
+func (s *Server) PoolMembers(app Service) []Service {
+	members := make([]Service, 0, app.WarmPool)
+	for i := 0; i < app.WarmPool; i++ {
+		members = append(members, PoolService(app, i))
+	}
+	return members
+}
+
+// findPoolMember resolves a pool name like "app-pool-0" back to its service.
+func (s *Server) findPoolMember(name string) *Service {
+	app, n := s.poolOwner(name)
+	if app == nil {
+		return nil
+	}
+	member := PoolService(*app, n)
+	return &member
+}
+
+// poolOwner finds the service whose warm pool slot n is called name, or nil
+// if name isn't a pool container.
+func (s *Server) poolOwner(name string) (app *Service, n int) {
+	i := strings.LastIndex(name, "-pool-")
+	if i < 0 {
+		return nil, 0
+	}
+	n, err := strconv.Atoi(name[i+len("-pool-"):])
+	if err != nil || n < 0 {
+		return nil, 0
+	}
+	app = s.FindService(name[:i])
+	if app == nil || n >= app.WarmPool {
+		return nil, 0
+	}
+	return app, n
+}
+
+func poolAction(app Service) string {
+	if strings.ToLower(app.WarmPoolAction) == IdleStop {
+		return IdleStop
+	}
+	return IdlePause
+}
+
+// RunWarmPools keeps every service's warm pool topped up in the background.
+func (s *Server) RunWarmPools(ctx context.Context) {
+	for {
+		for _, app := range s.Config.Services {
+			for _, member := range s.PoolMembers(app) {
+				err := s.FillPool(member)
+				if err != nil {
+					s.Logger.Error("Error filling warm pool", "service", app.Name, "err", err)
+				}
+			}
+		}
+		if !sleep(ctx, 5*time.Second) {
+			return
+		}
+	}
+}
+
+// FillPool warms a pool slot if it is empty.
+func (s *Server) FillPool(member Service) (err error) {
+	err = s.PlaceService(member)
+	if err != nil {
+		return
+	}
+	cli, err := s.Docker(member.Name)
+	if err != nil {
+		return
+	}
+	var cont *types.Container
+	cont, err = s.FindContainer(cli, member.Name)
+	if err != nil || cont != nil {
+		return
+	}
+
+	s.Logger.Info("Warming pooled container", "service", member.Name)
+	err = s.LaunchContainer(s.ctx, member)
+	if err != nil {
+		return
+	}
+	return s.StopContainerWithAction(member.Name, poolAction(member), false)
+}
+
+// TakePooled hands one of app's warm pool containers over to app, or returns
+// nil if none is ready. The caller must hold app's ContainerAPILock.
+func (s *Server) TakePooled(cli *client.Client, app Service) (cont *types.Container, err error) {
+	for _, member := range s.PoolMembers(app) {
+		var taken bool
+		taken, err = s.takePoolMember(cli, app, member)
+		if err != nil {
+			return
+		}
+		if taken {
+			s.Logger.Info("Took pooled container", "service", app.Name, "member", member.Name)
+			return s.FindContainer(cli, app.Name)
+		}
+	}
+	return
+}
+
+func (s *Server) takePoolMember(cli *client.Client, app Service, member Service) (taken bool, err error) {
+	s.ContainerAPILock.Lock(member.Name)
+	defer s.ContainerAPILock.Unlock(member.Name)
+
+	var cont *types.Container
+	cont, err = s.FindContainer(cli, member.Name)
+	if err != nil || cont == nil || cont.State == "running" {
+		// still warming
+		return
+	}
+	ctx, cancel := s.dockerContext(s.ctx)
+	defer cancel()
+	err = cli.ContainerRename(ctx, cont.ID, s.ContainerName(app.Name))
+	if err != nil {
+		return
+	}
+	s.Promote(member, app)
+	return true, nil
+}
@@ -0,0 +1,93 @@
+package fishingboat
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parsePortRange reads "27015-27020", or a single port.
+func parsePortRange(text string) (first int, last int, err error) {
+	low, high, isRange := strings.Cut(strings.TrimSpace(text), "-")
+	first, err = strconv.Atoi(low)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q", text)
+	}
+	last = first
+	if isRange {
+		last, err = strconv.Atoi(high)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid port range %q", text)
+		}
+	}
+	if first < 1 || last > 65535 || first > last {
+		return 0, 0, fmt.Errorf("invalid port range %q", text)
+	}
+	return
+}
+
+// expandPortRange turns a mapping of a range of container ports into one
+// mapping per port. Each host range lines up with the container range, which
+// is published on the same host ports if no host ranges are given.
+func expandPortRange(port PortMapping) (ports []PortMapping, err error) {
+	if port.ContainerPorts == "" {
+		if len(port.HostPortRanges) > 0 {
+			return nil, fmt.Errorf("hostPortRanges need containerPorts")
+		}
+		return []PortMapping{port}, nil
+	}
+	if port.ContainerPort != 0 || len(port.HostPorts) > 0 {
+		return nil, fmt.Errorf("containerPorts %s can't be combined with containerPort or hostPorts", port.ContainerPorts)
+	}
+	first, last, err := parsePortRange(port.ContainerPorts)
+	if err != nil {
+		return
+	}
+	hostRanges := port.HostPortRanges
+	if len(hostRanges) == 0 {
+		hostRanges = []string{port.ContainerPorts}
+	}
+	hostFirsts := make([]int, 0, len(hostRanges))
+	for _, hostRange := range hostRanges {
+		hostFirst, hostLast, err := parsePortRange(hostRange)
+		if err != nil {
+			return nil, err
+		}
+		if hostLast-hostFirst != last-first {
+			return nil, fmt.Errorf("host ports %s aren't as many as container ports %s", hostRange, port.ContainerPorts)
+		}
+		hostFirsts = append(hostFirsts, hostFirst)
+	}
+	for i := 0; i <= last-first; i++ {
+		single := port
+		single.ContainerPorts = ""
+		single.HostPortRanges = nil
+		single.ContainerPort = first + i
+		single.HostPorts = make([]int, 0, len(hostFirsts))
+		for _, hostFirst := range hostFirsts {
+			single.HostPorts = append(single.HostPorts, hostFirst+i)
+		}
+		ports = append(ports, single)
+	}
+	return
+}
+
+// expandPortRanges expands every service's port ranges, leaving the
+// configured services untouched.
+func expandPortRanges(services []Service) (expanded []Service, err error) {
+	expanded = make([]Service, 0, len(services))
+	for _, app := range services {
+		ports := make([]PortMapping, 0, len(app.Ports))
+		for _, port := range app.Ports {
+			var singles []PortMapping
+			singles, err = expandPortRange(port)
+			if err != nil {
+				return nil, fmt.Errorf("service %s: %w", app.Name, err)
+			}
+			ports = append(ports, singles...)
+		}
+		app.Ports = ports
+		expanded = append(expanded, app)
+	}
+	return
+}
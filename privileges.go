@@ -0,0 +1,88 @@
+//go:build !windows
+
+package fishingboat
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// first file descriptor passed by systemd socket activation
+const listenFdsStart = 3
+
+// SystemdListeners returns the sockets systemd passed us through socket
+// activation, by port, so the proxy can serve low ports without binding
+// them itself.
+func SystemdListeners() (listeners map[int]net.Listener, err error) {
+	listeners = make(map[int]net.Listener)
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return listeners, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil {
+		return nil, fmt.Errorf("bad LISTEN_FDS: %w", err)
+	}
+	// don't pass them on to hooks
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	for fd := listenFdsStart; fd < listenFdsStart+count; fd++ {
+		syscall.CloseOnExec(fd)
+		file := os.NewFile(uintptr(fd), "systemd-listener-"+strconv.Itoa(fd))
+		var listener net.Listener
+		listener, err = net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return
+		}
+		addr, ok := listener.Addr().(*net.TCPAddr)
+		if !ok {
+			listener.Close()
+			return nil, fmt.Errorf("socket %d is not TCP", fd)
+		}
+		listeners[addr.Port] = listener
+	}
+	return
+}
+
+// DropPrivileges switches to name, keeping its supplementary groups so it
+// can still reach the Docker socket through the docker group.
+func DropPrivileges(name string) (err error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return
+	}
+	groupIDs, err := u.GroupIds()
+	if err != nil {
+		return
+	}
+	groups := make([]int, 0, len(groupIDs))
+	for _, id := range groupIDs {
+		var group int
+		group, err = strconv.Atoi(id)
+		if err != nil {
+			return
+		}
+		groups = append(groups, group)
+	}
+	if err = syscall.Setgroups(groups); err != nil {
+		return
+	}
+	if err = syscall.Setgid(gid); err != nil {
+		return
+	}
+	return syscall.Setuid(uid)
+}
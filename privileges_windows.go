@@ -0,0 +1,17 @@
+package fishingboat
+
+import (
+	"errors"
+	"net"
+)
+
+// SystemdListeners returns no sockets, there is no socket activation on Windows.
+func SystemdListeners() (map[int]net.Listener, error) {
+	return make(map[int]net.Listener), nil
+}
+
+// DropPrivileges isn't supported on Windows. Install the service to run as
+// the account it should use instead.
+func DropPrivileges(name string) error {
+	return errors.New("--user is not supported on Windows, run the service as that account instead")
+}
@@ -0,0 +1,67 @@
+package fishingboat
+
+import (
+	"io"
+	"net"
+)
+
+// ProxyTuning adjusts how a TCP port's connections are copied, e.g. large
+// buffers for bulk transfers, or Nagle's algorithm back on.
+type ProxyTuning struct {
+	// bytes copied at a time in each direction, e.g. 262144. When unset the
+	// kernel copies between the sockets itself where it can.
+	BufferSize int `json:"bufferSize,omitempty"`
+	// override bufferSize from the client to the container, and back
+	UpstreamBufferSize   int `json:"upstreamBufferSize,omitempty"`
+	DownstreamBufferSize int `json:"downstreamBufferSize,omitempty"`
+	// send small writes straight away, on by default. Turning it off trades
+	// latency for fewer packets.
+	NoDelay *bool `json:"noDelay,omitempty"`
+}
+
+// proxyTuning is the port's tuning, or else the service's.
+func proxyTuning(app Service, port PortMapping) *ProxyTuning {
+	if port.Proxy != nil {
+		return port.Proxy
+	}
+	return app.Proxy
+}
+
+// BufferSizes are the copy buffer sizes toward the container and back, 0
+// where left to the default.
+func (t *ProxyTuning) BufferSizes() (upstream int, downstream int) {
+	if t == nil {
+		return
+	}
+	upstream, downstream = t.BufferSize, t.BufferSize
+	if t.UpstreamBufferSize > 0 {
+		upstream = t.UpstreamBufferSize
+	}
+	if t.DownstreamBufferSize > 0 {
+		downstream = t.DownstreamBufferSize
+	}
+	return
+}
+
+// Apply sets the socket options on one side of a proxied connection.
+func (t *ProxyTuning) Apply(conn net.Conn) {
+	if t == nil || t.NoDelay == nil {
+		return
+	}
+	if tlsConn, ok := conn.(interface{ NetConn() net.Conn }); ok {
+		conn = tlsConn.NetConn()
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetNoDelay(*t.NoDelay)
+	}
+}
+
+// copyBuffered is io.Copy through a buffer of size bytes, or plain io.Copy
+// if size is 0.
+func copyBuffered(dst io.Writer, src io.Reader, size int) (int64, error) {
+	if size <= 0 {
+		return io.Copy(dst, src)
+	}
+	// hide ReadFrom and WriteTo, which would skip the buffer
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, make([]byte, size))
+}
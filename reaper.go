@@ -0,0 +1,80 @@
+package fishingboat
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Clock is the reaper's source of time, so scale-down can be driven by a
+// fake clock.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+func (s *Server) reapInterval() time.Duration {
+	if s.Config.ReapInterval > 0 {
+		return time.Duration(s.Config.ReapInterval) * time.Second
+	}
+	return 10 * time.Second
+}
+
+// waitToReap sleeps until the next time a service could be due to stop or
+// recycle, a kill time changes, or the reap interval passes. Services held
+// up by connections are woken for when those close.
+func (s *Server) waitToReap(ctx context.Context) {
+	wait := s.reapInterval()
+	func() {
+		s.ServerLock.RLock()
+		defer s.ServerLock.RUnlock()
+		now := s.Clock.Now()
+		due := func(at time.Time) {
+			if d := at.Sub(now); d > 0 && d < wait {
+				wait = d
+			}
+		}
+		for name, killTime := range s.KillTimes() {
+			at := killTime
+			if warmUntil := s.ServiceWarmUntil[name]; warmUntil.After(at) {
+				at = warmUntil
+			}
+			if app := s.FindService(name); app != nil {
+				if t := s.ServiceStartTime[name].Add(time.Duration(app.MinUptime) * time.Second); t.After(at) {
+					at = t
+				}
+				if strings.ToLower(app.IdleMode) == IdleCPU {
+					if t := s.ServiceLastBusy[name].Add(time.Duration(app.CoolDown) * time.Second); t.After(at) {
+						at = t
+					}
+				}
+			}
+			due(at)
+		}
+		for _, app := range s.Instances() {
+			if startTime, ok := s.ServiceStartTime[app.Name]; ok && app.MaxLifetime > 0 {
+				due(startTime.Add(time.Duration(app.MaxLifetime) * time.Second))
+			}
+		}
+	}()
+	select {
+	case <-s.Clock.After(wait):
+	case <-s.reaperWake:
+	case <-ctx.Done():
+	}
+}
+
+// sleep waits for d, reporting false if ctx is cancelled first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
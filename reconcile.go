@@ -0,0 +1,81 @@
+package fishingboat
+
+import (
+	"context"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+func (s *Server) reconcileInterval() time.Duration {
+	if s.Config.ReconcileInterval > 0 {
+		return time.Duration(s.Config.ReconcileInterval) * time.Second
+	}
+	return 30 * time.Second
+}
+
+// Reconcile checks what we track for each service against its container in
+// Docker, so changes made behind our back, like an operator running docker
+// stop or docker start, are picked up without a restart.
+func (s *Server) Reconcile(ctx context.Context) {
+	for sleep(ctx, s.reconcileInterval()) {
+		for _, app := range s.Instances() {
+			err := s.reconcileService(app)
+			if err != nil {
+				s.Logger.Error("Error reconciling service", "service", app.Name, "err", err)
+			}
+		}
+	}
+}
+
+func (s *Server) reconcileService(app Service) (err error) {
+	// nothing is launching or stopping it while we look
+	s.ContainerAPILock.Lock(app.Name)
+	defer s.ContainerAPILock.Unlock(app.Name)
+
+	cli, err := s.Docker(app.Name)
+	if err != nil {
+		return
+	}
+	var cont *types.Container
+	cont, err = s.FindContainer(cli, app.Name)
+	if err != nil {
+		return
+	}
+	var tracked bool
+	var portsFrom string
+	func() {
+		s.ServerLock.RLock()
+		defer s.ServerLock.RUnlock()
+		_, tracked = s.ServiceStartTime[app.Name]
+		portsFrom = s.ServicePortsContainer[app.Name]
+	}()
+	alive := cont != nil && (cont.State == "running" || cont.State == "paused")
+	logger := s.Logger.With("service", app.Name)
+
+	switch {
+	case tracked && !alive:
+		logger.Warn("Container stopped outside fishingboat, releasing it")
+		s.ReleaseDependencies(app)
+		func() {
+			s.ServerLock.Lock()
+			defer s.ServerLock.Unlock()
+			delete(s.ServiceStartTime, app.Name)
+		}()
+		s.ClearKillTime(app.Name)
+		s.ReleaseResources(app)
+		if cont == nil {
+			s.ForgetPortMappings(app.Name)
+			s.Unplace(app.Name)
+		}
+		s.AuditReason(AuditStop, app, "stopped outside fishingboat", "", nil)
+		s.Emit(EventStopped, app, nil)
+	case !tracked && alive:
+		logger.Warn("Container started outside fishingboat, adopting it")
+		err = s.adoptContainer(app, nil)
+	case tracked && cont.ID != portsFrom:
+		logger.Warn("Container was replaced outside fishingboat, reloading its ports", "container", cont.ID)
+		err = s.LoadPortMappings(cli, app, cont.ID)
+	}
+	return
+}
@@ -0,0 +1,268 @@
+package fishingboat
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	LeastConnections = "leastconnections"
+	RoundRobin       = "roundrobin"
+	Random           = "random"
+)
+
+const (
+	AffinitySourceIP = "sourceip"
+	AffinityCookie   = "cookie"
+)
+
+type affinity struct {
+	replica  string
+	lastSeen time.Time
+}
+
+// ReplicaService derives replica i of app. Replica 0 is app itself; the rest
+// are name-suffixed and otherwise managed like standalone services, each with
+// their own container, host ports, connection count, and cooldown.
+func ReplicaService(app Service, i int) Service {
+	if i == 0 {
+		return app
+	}
+	replica := app
+	replica.Name = fmt.Sprintf("%s-%d", app.Name, i)
+	replica.Replica = i
+	replica.ReplicaOf = app.Name
+	// only the primary wakes groups and follows schedules
+	replica.Group = ""
+	replica.Schedules = nil
+	return replica
+}
+
+func (s *Server) Replicas(app Service) []Service {
+	replicas := []Service{app}
+	for i := 1; i < app.MaxReplicas; i++ {
+		replicas = append(replicas, ReplicaService(app, i))
+	}
+	return replicas
+}
+
+// Instances lists every configured service along with its extra replicas
+// and warm pool containers.
+func (s *Server) Instances() []Service {
+	instances := make([]Service, 0, len(s.Config.Services))
+	for _, app := range s.Config.Services {
+		instances = append(instances, s.Replicas(app)...)
+		instances = append(instances, s.PoolMembers(app)...)
+	}
+	return instances
+}
+
+// ValidateInstanceNames makes sure no replica or warm pool container is
+// named like another service, e.g. a service "app-2" next to "app" with
+// three replicas, since they would share a container and FindService
+// couldn't tell them apart.
+func (s *Server) ValidateInstanceNames() error {
+	owners := make(map[string]string)
+	for _, app := range s.Config.Services {
+		owners[app.Name] = app.Name
+	}
+	for _, app := range s.Config.Services {
+		for _, instance := range append(s.Replicas(app)[1:], s.PoolMembers(app)...) {
+			if owner, ok := owners[instance.Name]; ok {
+				return fmt.Errorf("service %s: container %s is also a container of service %s", app.Name, instance.Name, owner)
+			}
+			owners[instance.Name] = app.Name
+		}
+	}
+	return nil
+}
+
+// findReplica resolves a replica name like "app-2" back to its service.
+// Configured names are matched before it is called, and
+// ValidateInstanceNames rules out the rest of the ambiguity.
+func (s *Server) findReplica(name string) *Service {
+	i := strings.LastIndex(name, "-")
+	if i < 0 {
+		return nil
+	}
+	n, err := strconv.Atoi(name[i+1:])
+	if err != nil || n < 1 {
+		return nil
+	}
+	for _, app := range s.Config.Services {
+		if app.Name == name[:i] && n < app.MaxReplicas {
+			replica := ReplicaService(app, n)
+			return &replica
+		}
+	}
+	return nil
+}
+
+// PickReplica chooses which replica of app a new connection goes to, sending
+// clients with session affinity back to the replica they last used.
+func (s *Server) PickReplica(app Service, client net.Addr) Service {
+	if app.MaxReplicas <= 1 {
+		return app
+	}
+	s.ServerLock.Lock()
+	defer s.ServerLock.Unlock()
+
+	key := s.affinityKey(app, client)
+	if key != "" {
+		if a, ok := s.ServiceAffinity[app.Name][key]; ok && time.Since(a.lastSeen) < affinityTimeout(app) {
+			for _, replica := range s.Replicas(app)[:s.ReplicaLimit(app)] {
+				if replica.Name == a.replica {
+					s.ServiceAffinity[app.Name][key] = affinity{replica: replica.Name, lastSeen: time.Now()}
+					return replica
+				}
+			}
+		}
+	}
+	replica := s.pickReplica(app)
+	if key != "" {
+		if _, ok := s.ServiceAffinity[app.Name]; !ok {
+			s.ServiceAffinity[app.Name] = make(map[string]affinity)
+		}
+		// drop stale clients while we're here
+		for k, a := range s.ServiceAffinity[app.Name] {
+			if time.Since(a.lastSeen) >= affinityTimeout(app) {
+				delete(s.ServiceAffinity[app.Name], k)
+			}
+		}
+		s.ServiceAffinity[app.Name][key] = affinity{replica: replica.Name, lastSeen: time.Now()}
+	}
+	return replica
+}
+
+// PickRequestReplica is PickReplica for an HTTP request. With cookie
+// affinity the client goes back to the replica named in its cookie while
+// that replica may still take connections, and the cookie is (re)set on
+// every response so it lasts affinityTimeout past the last request.
+func (s *Server) PickRequestReplica(w http.ResponseWriter, r *http.Request, app Service, client net.Addr) Service {
+	if app.MaxReplicas <= 1 || strings.ToLower(app.SessionAffinity) != AffinityCookie {
+		return s.PickReplica(app, client)
+	}
+	name := "fishingboat-" + app.Name
+	var replica Service
+	ok := false
+	if cookie, err := r.Cookie(name); err == nil {
+		if i, err := strconv.Atoi(cookie.Value); err == nil {
+			replica, ok = s.allowedReplica(app, i)
+		}
+	}
+	if !ok {
+		replica = s.PickReplica(app, client)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    strconv.Itoa(replica.Replica),
+		Path:     "/",
+		MaxAge:   int(affinityTimeout(app).Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return replica
+}
+
+// allowedReplica is replica i of app, if it may take connections.
+func (s *Server) allowedReplica(app Service, i int) (Service, bool) {
+	s.ServerLock.RLock()
+	defer s.ServerLock.RUnlock()
+	if i < 0 || i >= s.ReplicaLimit(app) {
+		return Service{}, false
+	}
+	return ReplicaService(app, i), true
+}
+
+func affinityTimeout(app Service) time.Duration {
+	if app.AffinityTimeout > 0 {
+		return time.Duration(app.AffinityTimeout) * time.Second
+	}
+	return 10 * time.Minute
+}
+
+// affinityKey identifies the client for app's session affinity, if any.
+func (s *Server) affinityKey(app Service, client net.Addr) string {
+	switch strings.ToLower(app.SessionAffinity) {
+	case None:
+		return ""
+	case AffinitySourceIP:
+		if client == nil {
+			return ""
+		}
+		host, _, err := net.SplitHostPort(client.String())
+		if err != nil {
+			return client.String()
+		}
+		return host
+	case AffinityCookie:
+		// PickRequestReplica handles it on HTTP ports, raw TCP has no
+		// cookies to go by
+	default:
+		s.Logger.Warn("Unknown session affinity", "service", app.Name, "affinity", app.SessionAffinity)
+	}
+	return ""
+}
+
+// pickReplica starts a new replica once every running one has at least
+// replicaConnections, otherwise balances the running replicas according to
+// loadBalancing. ServerLock must be held.
+func (s *Server) pickReplica(app Service) Service {
+
+	threshold := app.ReplicaConnections
+	if app.Autoscale != nil && app.Autoscale.TargetConnections > 0 {
+		threshold = app.Autoscale.TargetConnections
+	}
+	running := make([]Service, 0)
+	var idle *Service
+	for _, replica := range s.Replicas(app)[:s.ReplicaLimit(app)] {
+		replica := replica
+		_, started := s.ServiceStartTime[replica.Name]
+		if !started && s.ConnCount(replica.Name) == 0 {
+			if idle == nil {
+				idle = &replica
+			}
+			continue
+		}
+		running = append(running, replica)
+	}
+	if len(running) == 0 {
+		return app
+	}
+	if idle != nil && threshold > 0 {
+		saturated := true
+		for _, replica := range running {
+			if s.ConnCount(replica.Name) < uint(threshold) {
+				saturated = false
+				break
+			}
+		}
+		if saturated {
+			return *idle
+		}
+	}
+
+	switch strings.ToLower(app.LoadBalancing) {
+	case RoundRobin:
+		next := s.ServiceNextReplica[app.Name] % len(running)
+		s.ServiceNextReplica[app.Name] = next + 1
+		return running[next]
+	case Random:
+		return running[rand.Intn(len(running))]
+	case LeastConnections, None:
+	default:
+		s.Logger.Warn("Unknown load balancing strategy", "service", app.Name, "strategy", app.LoadBalancing)
+	}
+	best := running[0]
+	for _, replica := range running[1:] {
+		if s.ConnCount(replica.Name) < s.ConnCount(best.Name) {
+			best = replica
+		}
+	}
+	return best
+}
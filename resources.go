@@ -0,0 +1,321 @@
+package fishingboat
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+const (
+	ResourcesFailFast = "fail"
+	ResourcesWait     = "wait"
+)
+
+type InsufficientResourcesError struct {
+	Resource string
+}
+
+func (e *InsufficientResourcesError) Error() string {
+	return fmt.Sprintf("not enough %s resources to launch container", e.Resource)
+}
+
+// DetectLimits sets the allocation limits from the host's CPUs, memory, and
+// video memory, less the configured reservation.
+func (s *Server) DetectLimits() (err error) {
+	cli, err := s.DockerHost("")
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := s.dockerContext(s.ctx)
+	defer cancel()
+	var info types.Info
+	info, err = cli.Info(ctx)
+	if err != nil {
+		return
+	}
+	reserved := s.Config.Resources.Reserved
+	limits := &s.Config.Resources.Limits
+	limits.MilliCPU = info.NCPU*1000 - reserved.MilliCPU
+	limits.MemoryMi = int(info.MemTotal/1024/1024) - reserved.MemoryMi
+
+	gpu, gpuErr := QueryGpuMemory()
+	if gpuErr != nil {
+		s.Logger.Warn("Could not detect video memory, keeping configured limit", "err", gpuErr)
+	} else {
+		limits.GpuMemoryMi = gpu.TotalMi - reserved.GpuMemoryMi
+	}
+	s.Logger.Info("Detected allocation limits", "mcpu", limits.MilliCPU, "memoryMi", limits.MemoryMi, "gpuMemoryMi", limits.GpuMemoryMi)
+	return
+}
+
+// SchedulingLimits applies the overcommit ratios to the allocation limits.
+// Unset ratios keep the limit strict.
+func (s *Server) SchedulingLimits() (limits Resources) {
+	return s.overcommitted(s.Config.Resources.Limits)
+}
+
+func (s *Server) overcommitted(allocation Resources) (limits Resources) {
+	scale := func(limit int, ratio float64) int {
+		if ratio <= 0 {
+			return limit
+		}
+		return int(float64(limit) * ratio)
+	}
+	overcommit := s.Config.Resources.Overcommit
+	limits.MilliCPU = scale(allocation.MilliCPU, overcommit.MilliCPU)
+	limits.MemoryMi = scale(allocation.MemoryMi, overcommit.MemoryMi)
+	limits.GpuMemoryMi = scale(allocation.GpuMemoryMi, overcommit.GpuMemoryMi)
+	return
+}
+
+// ReserveResources atomically checks app's request against the allocation
+// limits, or its node's if it was placed on one, and reserves it.
+func (s *Server) ReserveResources(app Service) error {
+	s.TrackedResourcesLock.Lock()
+	defer s.TrackedResourcesLock.Unlock()
+	if node := s.FindNode(s.ServiceNodes[app.Name]); node != nil {
+		free := s.nodeHeadroom(node)
+		if app.ResourceRequest.MilliCPU > free.MilliCPU {
+			return &InsufficientResourcesError{"cpu"}
+		}
+		if app.ResourceRequest.MemoryMi > free.MemoryMi {
+			return &InsufficientResourcesError{"memory"}
+		}
+		if app.ResourceRequest.GpuMemoryMi > free.GpuMemoryMi {
+			return &InsufficientResourcesError{"video memory"}
+		}
+		s.allocate(app, 1)
+		return nil
+	}
+	over := s.UsageOverRequests()
+	limits := s.SchedulingLimits()
+	if s.TrackedResources.MilliCPU+over.MilliCPU+app.ResourceRequest.MilliCPU > limits.MilliCPU {
+		return &InsufficientResourcesError{"cpu"}
+	}
+	if s.TrackedResources.MemoryMi+over.MemoryMi+app.ResourceRequest.MemoryMi > limits.MemoryMi {
+		return &InsufficientResourcesError{"memory"}
+	}
+	if s.TrackedResources.GpuMemoryMi+over.GpuMemoryMi+app.ResourceRequest.GpuMemoryMi > limits.GpuMemoryMi {
+		return &InsufficientResourcesError{"video memory"}
+	}
+	// the card may be full of things we don't manage
+	if s.MeasuredGpu != nil && app.ResourceRequest.GpuMemoryMi > 0 &&
+		s.MeasuredGpu.UsedMi+app.ResourceRequest.GpuMemoryMi > s.MeasuredGpu.TotalMi {
+		return &InsufficientResourcesError{"video memory"}
+	}
+	s.allocate(app, 1)
+	return nil
+}
+
+// allocate adds (sign 1) or removes (sign -1) app's request from the ledger
+// and its node's. TrackedResourcesLock must be held.
+func (s *Server) allocate(app Service, sign int) {
+	s.TrackedResources.MilliCPU += sign * app.ResourceRequest.MilliCPU
+	s.TrackedResources.MemoryMi += sign * app.ResourceRequest.MemoryMi
+	s.TrackedResources.GpuMemoryMi += sign * app.ResourceRequest.GpuMemoryMi
+	if node, ok := s.ServiceNodes[app.Name]; ok {
+		allocated := s.NodeAllocated[node]
+		allocated.MilliCPU += sign * app.ResourceRequest.MilliCPU
+		allocated.MemoryMi += sign * app.ResourceRequest.MemoryMi
+		allocated.GpuMemoryMi += sign * app.ResourceRequest.GpuMemoryMi
+		s.NodeAllocated[node] = allocated
+	}
+}
+
+// resourceWaitTimeout is how long the wait policy holds on for resources,
+// five minutes unless the service says otherwise.
+func resourceWaitTimeout(app Service) time.Duration {
+	if app.ResourceWaitTimeout > 0 {
+		return time.Duration(app.ResourceWaitTimeout) * time.Second
+	}
+	return 5 * time.Minute
+}
+
+// AwaitResources reserves app's resources, holding on for other services to
+// cool down, up to its resourceWaitTimeout, if its resource policy is to
+// wait. It gives up early once ctx is done.
+func (s *Server) AwaitResources(ctx context.Context, app Service) (err error) {
+	reserve := func() (err error) {
+		err = s.ReserveResources(app)
+		for err != nil && (s.EvictIdle(app) || s.PreemptActive(app)) {
+			err = s.ReserveResources(app)
+		}
+		return
+	}
+	err = reserve()
+	if err == nil || strings.ToLower(app.ResourcePolicy) != ResourcesWait {
+		return
+	}
+	s.Logger.Info("Waiting for resources", "service", app.Name, "err", err)
+	deadline := s.Clock.Now().Add(resourceWaitTimeout(app))
+	for s.Clock.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.Clock.After(500 * time.Millisecond):
+		}
+		err = reserve()
+		if err == nil {
+			return
+		}
+	}
+	return
+}
+
+// EvictIdle stops a running service with no connections to make room for
+// app. Lower priority services go first, then the least recently used. Equal
+// or higher priority services are only evicted with evictIdle enabled. It
+// reports whether anything was evicted.
+func (s *Server) EvictIdle(app Service) bool {
+	victim := s.PickVictim(app, false)
+	if victim == nil {
+		return false
+	}
+	s.Logger.Info("Evicting idle service", "service", victim.Name, "for", app.Name)
+	if !s.Evict(*victim, false) {
+		return false
+	}
+	s.AuditReason(AuditEvict, *victim, "idle, to make room for "+app.Name, "", nil)
+	return true
+}
+
+// PreemptActive gracefully stops a lower priority service even though it
+// has connections, if preemptActive is enabled.
+func (s *Server) PreemptActive(app Service) bool {
+	if !s.Config.PreemptActive {
+		return false
+	}
+	victim := s.PickVictim(app, true)
+	if victim == nil {
+		return false
+	}
+	s.Logger.Warn("Preempting active service", "service", victim.Name, "priority", victim.Priority, "for", app.Name, "forPriority", app.Priority)
+	if !s.Evict(*victim, true) {
+		return false
+	}
+	s.AuditReason(AuditEvict, *victim, "preempted by "+app.Name, "", nil)
+	return true
+}
+
+// PickVictim never picks what app is starting alongside, its group and
+// everything they depend on, nor a service still within its minUptime.
+func (s *Server) PickVictim(app Service, active bool) (victim *Service) {
+	// only services on the same node make room
+	placements := s.Placements()
+	needed := map[string]bool{app.Name: true}
+	for _, member := range s.GroupMembers(app) {
+		needed[member.Name] = true
+		for name := range s.Dependencies(member) {
+			needed[name] = true
+		}
+	}
+	for name := range s.Dependencies(app) {
+		needed[name] = true
+	}
+	now := s.Clock.Now()
+	s.ServerLock.RLock()
+	defer s.ServerLock.RUnlock()
+	for _, instance := range s.Instances() {
+		instance := instance
+		candidate := &instance
+		if needed[candidate.Name] || candidate.ResourceRequest == nil {
+			continue
+		}
+		if candidate.ResourceRequest.MilliCPU == 0 && candidate.ResourceRequest.MemoryMi == 0 && candidate.ResourceRequest.GpuMemoryMi == 0 {
+			continue // frees nothing
+		}
+		if placements[candidate.Name] != placements[app.Name] {
+			continue
+		}
+		startTime, running := s.ServiceStartTime[candidate.Name]
+		if !running {
+			continue
+		}
+		// give a fresh container time to pay off its cold start
+		if now.Sub(startTime) < time.Duration(candidate.MinUptime)*time.Second {
+			continue
+		}
+		if active {
+			if s.ConnCount(candidate.Name) == 0 || candidate.Priority >= app.Priority {
+				continue
+			}
+		} else {
+			if s.ConnCount(candidate.Name) > 0 {
+				continue
+			}
+			if !s.Config.EvictIdle && candidate.Priority >= app.Priority {
+				continue
+			}
+		}
+		if victim == nil || candidate.Priority < victim.Priority ||
+			(candidate.Priority == victim.Priority && s.LastUsed(candidate.Name).Before(s.LastUsed(victim.Name))) {
+			victim = candidate
+		}
+	}
+	return
+}
+
+func (s *Server) Evict(victim Service, force bool) bool {
+	idleAction := victim.IdleAction
+	if strings.ToLower(idleAction) == IdlePause {
+		// a paused container keeps its reservation
+		idleAction = IdleStop
+	}
+	// another node may be waking it
+	unlock, ok := s.Cluster.TryLock(s.ctx, victim.Name)
+	if !ok {
+		return false
+	}
+	err := s.StopContainerWithAction(victim.Name, idleAction, force)
+	unlock()
+	if err != nil {
+		s.Logger.Error("Error evicting service", "service", victim.Name, "err", err)
+		return false
+	}
+	s.ClearKillTime(victim.Name)
+	return true
+}
+
+func (s *Server) ReleaseResources(app Service) {
+	s.TrackedResourcesLock.Lock()
+	defer s.TrackedResourcesLock.Unlock()
+	s.allocate(app, -1)
+	delete(s.GpuAllocations, app.Name)
+	delete(s.CpuAllocations, app.Name)
+}
+
+// TrackAllocations records which GPUs and pinned cores a started container actually holds.
+func (s *Server) TrackAllocations(cli *client.Client, app Service, contID string) error {
+	ctx, cancel := s.dockerContext(s.ctx)
+	defer cancel()
+	inspect, err := cli.ContainerInspect(ctx, contID)
+	if err != nil {
+		return err
+	}
+	gpus := make([]string, 0)
+	for _, request := range inspect.HostConfig.DeviceRequests {
+		gpus = append(gpus, request.DeviceIDs...)
+	}
+	var cpus []int
+	if app.ResourceRequest.PinCpus > 0 {
+		cpus, err = ParseCpuList(inspect.HostConfig.CpusetCpus)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.TrackedResourcesLock.Lock()
+	defer s.TrackedResourcesLock.Unlock()
+	if len(gpus) > 0 {
+		s.GpuAllocations[app.Name] = gpus
+	}
+	if len(cpus) > 0 {
+		s.CpuAllocations[app.Name] = cpus
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package fishingboat
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+)
+
+// parseRestartPolicy reads a policy the way docker run --restart does: no,
+// always, unless-stopped, or on-failure with an optional :retries.
+func parseRestartPolicy(policy string) (parsed container.RestartPolicy, err error) {
+	name, retries, hasRetries := strings.Cut(policy, ":")
+	parsed.Name = name
+	switch name {
+	case "no", "always", "unless-stopped":
+		if hasRetries {
+			err = fmt.Errorf("restart policy %s doesn't take a retry count", name)
+		}
+	case "on-failure":
+		if hasRetries {
+			parsed.MaximumRetryCount, err = strconv.Atoi(retries)
+			if err == nil && parsed.MaximumRetryCount < 0 {
+				err = fmt.Errorf("negative retry count")
+			}
+		}
+	default:
+		err = fmt.Errorf("unknown restart policy %s", policy)
+	}
+	return
+}
+
+// ValidateRestartPolicies checks every service's restart policy parses.
+func (s *Server) ValidateRestartPolicies() error {
+	for _, app := range s.Config.Services {
+		if app.RestartPolicy == "" {
+			continue
+		}
+		if _, err := parseRestartPolicy(app.RestartPolicy); err != nil {
+			return fmt.Errorf("service %s: %w", app.Name, err)
+		}
+	}
+	return nil
+}
+
+// RestartPolicy is the policy app's container is created with, from its
+// restartPolicy or else its hostConfig.
+func RestartPolicy(app Service) (policy container.RestartPolicy) {
+	if app.RestartPolicy != "" {
+		// checked at startup
+		policy, _ = parseRestartPolicy(app.RestartPolicy)
+		return
+	}
+	if app.HostConfig != nil {
+		policy = app.HostConfig.RestartPolicy
+	}
+	return
+}
+
+// setRestartPolicy changes a container's restart policy in place. Containers
+// are put back on "no" before fishingboat stops them, so Docker doesn't bring
+// back what was just scaled down, e.g. an always container when the daemon
+// restarts, and rearmed before they are started again.
+func (s *Server) setRestartPolicy(cli *client.Client, app Service, contID string, policy container.RestartPolicy) {
+	if configured := RestartPolicy(app); configured.IsNone() {
+		return
+	}
+	ctx, cancel := s.dockerContext(s.ctx)
+	defer cancel()
+	_, err := cli.ContainerUpdate(ctx, contID, container.UpdateConfig{RestartPolicy: policy})
+	if err != nil {
+		s.Logger.Warn("Error updating restart policy", "service", app.Name, "policy", policy.Name, "err", err)
+	}
+}
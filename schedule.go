@@ -0,0 +1,60 @@
+package fishingboat
+
+import (
+	"context"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Schedule keeps a service warm for Duration seconds every time Cron fires.
+type Schedule struct {
+	Cron     string `json:"cron"`
+	Duration int    `json:"duration"`
+}
+
+func (s *Server) RunSchedules(ctx context.Context) (err error) {
+	c := cron.New()
+	for _, app := range s.Config.Services {
+		for _, schedule := range app.Schedules {
+			app, schedule := app, schedule
+			_, err = c.AddFunc(schedule.Cron, func() { s.WarmService(app, schedule) })
+			if err != nil {
+				s.Logger.Error("Error parsing schedule", "service", app.Name, "cron", schedule.Cron, "err", err)
+				return
+			}
+			s.Logger.Info("Scheduled warm window", "service", app.Name, "cron", schedule.Cron)
+		}
+	}
+	c.Start()
+	go func() {
+		<-ctx.Done()
+		c.Stop()
+	}()
+	return
+}
+
+func (s *Server) WarmService(app Service, schedule Schedule) {
+	s.Logger.Info("Warming service", "service", app.Name, "seconds", schedule.Duration)
+	s.AuditReason(AuditWake, app, "schedule "+schedule.Cron, "", nil)
+	err := s.LaunchContainer(s.ctx, app)
+	if err != nil {
+		s.Logger.Error("Error launching container", "service", app.Name, "err", err)
+		return
+	}
+	s.KeepWarm(app, s.Clock.Now().Add(time.Duration(schedule.Duration)*time.Second))
+}
+
+// KeepWarm holds off scaling down a running service until warmUntil, even
+// without connections.
+func (s *Server) KeepWarm(app Service, warmUntil time.Time) {
+	s.ServerLock.Lock()
+	defer s.ServerLock.Unlock()
+	if warmUntil.After(s.ServiceWarmUntil[app.Name]) {
+		s.ServiceWarmUntil[app.Name] = warmUntil
+	}
+	// scale down once the window closes if nobody is connected
+	if s.ConnCount(app.Name) == 0 {
+		s.SetKillTime(app.Name, s.ServiceWarmUntil[app.Name])
+	}
+}
@@ -0,0 +1,147 @@
+package fishingboat
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// Runtime connects to the Docker daemon at host, or the one from the
+// environment if host is empty. The server keeps one client per host.
+type Runtime func(host string) (*client.Client, error)
+
+func DockerRuntime(host string) (*client.Client, error) {
+	if host == "" {
+		return client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	}
+	return client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation(), client.WithHost(host))
+}
+
+// ListenerFactory opens the proxy's listening sockets, like net.Listen.
+type ListenerFactory func(network, address string) (net.Listener, error)
+
+// Option customizes a Server built by NewServer, for programs embedding the
+// proxy.
+type Option func(*Server)
+
+// WithRuntime replaces how the server connects to Docker.
+func WithRuntime(runtime Runtime) Option {
+	return func(s *Server) { s.Runtime = runtime }
+}
+
+// WithLogger sends the server's logs to logger instead of slog's default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Server) { s.Logger = logger }
+}
+
+// WithListenerFactory opens proxy ports through listen instead of net.Listen,
+// e.g. to hand in sockets the embedding program already holds.
+func WithListenerFactory(listen ListenerFactory) Option {
+	return func(s *Server) { s.ListenerFactory = listen }
+}
+
+// newServer sets up a server's bookkeeping without starting any of the
+// optional subsystems.
+func newServer(config ServicesConfig) *Server {
+	return &Server{
+		Config:                     config,
+		ServiceConns:               make(map[string]*connState),
+		ServiceWarmUntil:           make(map[string]time.Time),
+		ServiceStartTime:           make(map[string]time.Time),
+		ServiceRecreatePending:     make(map[string]bool),
+		ServiceRemediating:         make(map[string]bool),
+		ServiceStartups:            make(map[string]*startup),
+		ServiceBreakers:            make(map[string]*breaker),
+		ServiceNextReplica:         make(map[string]int),
+		ServiceAutoscalers:         make(map[string]*autoscaler),
+		ServiceAffinity:            make(map[string]map[string]affinity),
+		ServiceSuccessors:          make(map[string]string),
+		ServiceColdStarts:          make(map[string][]ColdStart),
+		ServiceColdStartHistograms: make(map[string]*Histogram),
+		NotifyLastSent:             make(map[string]time.Time),
+		ServiceLastTraffic:         make(map[string]*atomic.Int64),
+		ServiceLastBusy:            make(map[string]time.Time),
+		HtpasswdFiles:              make(map[string]*htpasswd),
+		KeySets:                    make(map[string]*jwks),
+		Bans:                       make(map[string]*banRecord),
+		ClusterUsage:               make(map[string]remoteUsage),
+		BackendTransports:          make(map[string]*http.Transport),
+		Clock:                      realClock{},
+		reaperWake:                 make(chan struct{}, 1),
+		ctx:                        context.Background(),
+		ServiceProxyHostPortMap:    make(map[string]map[int]int),
+		ServicePortsContainer:      make(map[string]string),
+		DependencyHolds:            make(map[string]bool),
+		MeasuredResources:          make(map[string]Resources),
+		GpuAllocations:             make(map[string][]string),
+		CpuAllocations:             make(map[string][]int),
+		ServiceNodes:               make(map[string]string),
+		NodeAllocated:              make(map[string]Resources),
+		ContainerAPILock:           NewMutexMap(),
+		LaunchFlights:              NewFlightGroup(),
+		PullFlights:                NewFlightGroup(),
+		DockerClients:              make(map[string]*client.Client),
+		ServiceListeners:           make(map[string][]net.Listener),
+		ServiceDisabled:            make(map[string]bool),
+		Runtime:                    DockerRuntime,
+		Logger:                     slog.Default(),
+		ListenerFactory:            net.Listen,
+	}
+}
+
+// NewServer builds a server for config, opening the audit log, metrics,
+// state, and cluster connections it asks for. Call Start to run it.
+func NewServer(config ServicesConfig, options ...Option) (s *Server, err error) {
+	s = newServer(config)
+	for _, option := range options {
+		option(s)
+	}
+	s.Config.Services, err = expandPortRanges(config.Services)
+	if err != nil {
+		return
+	}
+	err = s.ValidateNaming()
+	if err != nil {
+		return
+	}
+	if config.MaxConnections > 0 {
+		s.ConnSlots = make(chan struct{}, config.MaxConnections)
+	}
+	s.AuditLog, err = NewAuditLog(config.Audit)
+	if err != nil {
+		return
+	}
+	if config.StatsD != nil {
+		s.StatsD, err = NewStatsD(*config.StatsD)
+		if err != nil {
+			return
+		}
+	}
+	if config.State != nil {
+		s.State = NewStateStore(*config.State)
+	}
+	if config.Cluster != nil {
+		s.Cluster, err = NewCluster(*config.Cluster, s.Logger)
+		if err != nil {
+			return
+		}
+	}
+	if config.Gossip != nil {
+		s.Gossip, err = NewGossip(*config.Gossip)
+		if err != nil {
+			return
+		}
+	}
+	if config.AccessLog != nil {
+		s.AccessLog, err = SetupAccessLog(config.AccessLog)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
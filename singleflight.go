@@ -0,0 +1,71 @@
+package fishingboat
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// FlightGroup runs one call per key at a time. Whoever asks while a call is
+// running waits for it and gets its result instead of running it again.
+type FlightGroup struct {
+	flights map[string]*flight
+	mutex   sync.Mutex
+}
+
+type flight struct {
+	done chan struct{}
+	err  error
+}
+
+func NewFlightGroup() *FlightGroup {
+	return &FlightGroup{flights: make(map[string]*flight)}
+}
+
+// Do runs fn unless a call for key is already running, in which case it
+// joins that one. fn gets a context with ctx's values and deadline but not
+// its cancellation, ended early only by stop, so one caller hanging up
+// doesn't fail the call for everyone who joined it. Each caller, the one
+// that started it included, stops waiting once its own ctx is done.
+func (g *FlightGroup) Do(ctx context.Context, stop context.Context, key string, fn func(ctx context.Context) error) error {
+	g.mutex.Lock()
+	f, ok := g.flights[key]
+	if !ok {
+		f = &flight{done: make(chan struct{})}
+		g.flights[key] = f
+		go g.run(ctx, stop, key, f, fn)
+	}
+	g.mutex.Unlock()
+
+	select {
+	case <-f.done:
+		return f.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (g *FlightGroup) run(ctx context.Context, stop context.Context, key string, f *flight, fn func(ctx context.Context) error) {
+	flightCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	if deadline, ok := ctx.Deadline(); ok {
+		flightCtx, cancel = context.WithDeadline(context.WithoutCancel(ctx), deadline)
+	}
+	defer cancel()
+	defer context.AfterFunc(stop, cancel)()
+
+	defer func() {
+		// waiters mustn't take a panic for success
+		p := recover()
+		if p != nil {
+			f.err = fmt.Errorf("panic: %v", p)
+		}
+		g.mutex.Lock()
+		delete(g.flights, key)
+		close(f.done)
+		g.mutex.Unlock()
+		if p != nil {
+			panic(p)
+		}
+	}()
+	f.err = fn(flightCtx)
+}
@@ -0,0 +1,237 @@
+package fishingboat
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"slices"
+
+	"github.com/docker/docker/api/types/blkiodev"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/strslice"
+)
+
+// label holding the hash of the create spec a managed container was built from
+const SpecHashLabel = "fishingboat.spec-hash"
+
+// Security locks down containers running untrusted images. It is added on
+// top of anything set in hostConfig.
+type Security struct {
+	// path to a seccomp profile, or "unconfined"
+	SeccompProfile string `json:"seccompProfile,omitempty"`
+	// name of a loaded AppArmor profile, or "unconfined"
+	AppArmorProfile string   `json:"appArmorProfile,omitempty"`
+	CapAdd          []string `json:"capAdd,omitempty"`
+	// e.g. ["ALL"] to start from nothing
+	CapDrop         []string `json:"capDrop,omitempty"`
+	NoNewPrivileges bool     `json:"noNewPrivileges,omitempty"`
+}
+
+// Apply adds the security options to hostConfig. Like the docker
+// CLI, seccomp profiles are read here and sent by content.
+func (sec *Security) Apply(hostConfig *container.HostConfig) error {
+	if sec == nil {
+		return nil
+	}
+	opts := append([]string(nil), hostConfig.SecurityOpt...)
+	switch sec.SeccompProfile {
+	case "":
+	case "unconfined":
+		opts = append(opts, "seccomp=unconfined")
+	default:
+		profile, err := os.ReadFile(sec.SeccompProfile)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, "seccomp="+string(profile))
+	}
+	if sec.AppArmorProfile != "" {
+		opts = append(opts, "apparmor="+sec.AppArmorProfile)
+	}
+	if sec.NoNewPrivileges && !slices.Contains(opts, "no-new-privileges") {
+		opts = append(opts, "no-new-privileges")
+	}
+	hostConfig.SecurityOpt = opts
+	hostConfig.CapAdd = append(append(strslice.StrSlice(nil), hostConfig.CapAdd...), sec.CapAdd...)
+	hostConfig.CapDrop = append(append(strslice.StrSlice(nil), hostConfig.CapDrop...), sec.CapDrop...)
+	return nil
+}
+
+// ContainerSpec builds the create configuration for a service. Host port
+// bindings are left out since they are allocated at create time.
+func (s *Server) ContainerSpec(app Service) (config container.Config, hostConfig container.HostConfig, err error) {
+	resources := container.Resources{}
+	if app.ResourceRequest.MemoryMi > 0 {
+		resources.Memory = int64(app.ResourceRequest.MemoryMi * 1024 * 1024)
+		resources.MemorySwap = resources.Memory
+	}
+	if app.ResourceRequest.MemorySwapMi > 0 {
+		resources.MemorySwap = int64(app.ResourceRequest.MemorySwapMi * 1024 * 1024)
+	} else if app.ResourceRequest.MemorySwapMi < 0 {
+		resources.MemorySwap = -1
+	}
+	resources.MemorySwappiness = app.ResourceRequest.MemorySwappiness
+	if app.ResourceRequest.MilliCPU > 0 {
+		resources.NanoCPUs = int64(app.ResourceRequest.MilliCPU * 1000000)
+	}
+	if app.ResourceRequest.GpuMemoryMi > 0 || app.Gpus != nil {
+		request := container.DeviceRequest{
+			Driver: "",
+			Count:  -1,
+			Capabilities: [][]string{
+				{"gpu"},
+			},
+		}
+		if app.Gpus != nil {
+			request.Driver = app.Gpus.Driver
+			if len(app.Gpus.DeviceIDs) > 0 {
+				request.Count = 0
+				request.DeviceIDs = app.Gpus.DeviceIDs
+			} else if app.Gpus.Count > 0 {
+				request.Count = app.Gpus.Count
+			}
+		}
+		resources.DeviceRequests = []container.DeviceRequest{request}
+	}
+	resources.CpusetCpus = app.ResourceRequest.CpusetCpus
+	resources.CpusetMems = app.ResourceRequest.CpusetMems
+	resources.BlkioWeight = app.ResourceRequest.BlkioWeight
+	resources.BlkioDeviceReadBps = throttleDevices(app.ResourceRequest.DeviceReadBps)
+	resources.BlkioDeviceWriteBps = throttleDevices(app.ResourceRequest.DeviceWriteBps)
+	resources.BlkioDeviceReadIOps = throttleDevices(app.ResourceRequest.DeviceReadIOps)
+	resources.BlkioDeviceWriteIOps = throttleDevices(app.ResourceRequest.DeviceWriteIOps)
+	if app.ResourceRequest.PidsLimit > 0 {
+		pidsLimit := app.ResourceRequest.PidsLimit
+		resources.PidsLimit = &pidsLimit
+	}
+	oomKillDisable := app.ResourceRequest.OomKillDisable
+	resources.OomKillDisable = &oomKillDisable
+
+	if app.Config != nil {
+		config = *app.Config
+	}
+	config.Image = app.Image
+	config.Cmd = app.Cmd
+	config.Env, err = ContainerEnv(app)
+	if err != nil {
+		return
+	}
+	vars := s.specVars(app)
+	config.Cmd, err = expandPlaceholders(config.Cmd, vars)
+	if err != nil {
+		return
+	}
+	config.Env, err = expandPlaceholders(config.Env, vars)
+	if err != nil {
+		return
+	}
+	app.Healthcheck.Apply(&config)
+	// don't share the label map with the service config
+	labels := make(map[string]string)
+	for k, v := range config.Labels {
+		labels[k] = v
+	}
+	config.Labels = labels
+
+	if app.HostConfig != nil {
+		hostConfig = *app.HostConfig
+	}
+	mounts, err := dockerMounts(app)
+	if err != nil {
+		return
+	}
+	hostConfig.Mounts = append(append([]mount.Mount(nil), hostConfig.Mounts...), mounts...)
+	hostConfig.RestartPolicy = RestartPolicy(app)
+	hostConfig.NetworkMode = container.NetworkMode("default")
+	if len(app.Networks) > 0 {
+		hostConfig.NetworkMode = container.NetworkMode(app.Networks[0].Name)
+	}
+	hostConfig.Resources = resources
+	if app.ResourceRequest.OomScoreAdj != 0 {
+		hostConfig.OomScoreAdj = app.ResourceRequest.OomScoreAdj
+	}
+	if app.LogDriver != nil {
+		app.LogDriver.Apply(&hostConfig)
+	} else if hostConfig.LogConfig.Type == "" {
+		s.Config.LogDriver.Apply(&hostConfig)
+	}
+	if s.Hardened(app) {
+		Harden(&hostConfig)
+	}
+	err = app.Security.Apply(&hostConfig)
+	return
+}
+
+// Hardened reports whether app gets the hardened defaults, which services
+// can opt out of with "hardened": false.
+func (s *Server) Hardened(app Service) bool {
+	if app.Hardened != nil {
+		return *app.Hardened
+	}
+	return s.Config.Hardened
+}
+
+// Harden makes the root filesystem read-only with a small writable /tmp,
+// drops every capability, and blocks privilege escalation. Services add back
+// what they need through security.capAdd and hostConfig.
+func Harden(hostConfig *container.HostConfig) {
+	hostConfig.ReadonlyRootfs = true
+	if !slices.Contains(hostConfig.CapDrop, "ALL") {
+		hostConfig.CapDrop = append(strslice.StrSlice{"ALL"}, hostConfig.CapDrop...)
+	}
+	if !slices.Contains(hostConfig.SecurityOpt, "no-new-privileges") {
+		hostConfig.SecurityOpt = append(append([]string(nil), hostConfig.SecurityOpt...), "no-new-privileges")
+	}
+	tmpfs := make(map[string]string)
+	for path, options := range hostConfig.Tmpfs {
+		tmpfs[path] = options
+	}
+	if _, ok := tmpfs["/tmp"]; !ok {
+		tmpfs["/tmp"] = "rw,noexec,nosuid,nodev,size=64m"
+	}
+	hostConfig.Tmpfs = tmpfs
+}
+
+func throttleDevices(limits []BlkioLimit) []*blkiodev.ThrottleDevice {
+	if len(limits) == 0 {
+		return nil
+	}
+	devices := make([]*blkiodev.ThrottleDevice, 0, len(limits))
+	for _, limit := range limits {
+		devices = append(devices, &blkiodev.ThrottleDevice{Path: limit.Path, Rate: limit.Rate})
+	}
+	return devices
+}
+
+// SpecHash fingerprints everything a container is created from, so drift in
+// the service config can be detected on existing containers.
+func SpecHash(app Service, config *container.Config, hostConfig *container.HostConfig) (string, error) {
+	containerPorts := make([]int, 0, len(app.Ports))
+	var directPorts []PortMapping
+	var transparentPorts []int
+	for _, port := range app.Ports {
+		if port.Direct() {
+			directPorts = append(directPorts, port)
+			continue
+		}
+		containerPorts = append(containerPorts, port.ContainerPort)
+		if port.Transparent {
+			transparentPorts = append(transparentPorts, port.ContainerPort)
+		}
+	}
+	buf, err := json.Marshal(struct {
+		Config           *container.Config
+		HostConfig       *container.HostConfig
+		ContainerPorts   []int
+		Networks         []NetworkAttachment `json:",omitempty"`
+		DirectPorts      []PortMapping       `json:",omitempty"`
+		TransparentPorts []int               `json:",omitempty"`
+	}{config, hostConfig, containerPorts, app.Networks, directPorts, transparentPorts})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,80 @@
+package fishingboat
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// startup is an in-flight wake of a service that connections queue behind.
+type startup struct {
+	done    chan struct{}
+	err     error
+	waiting int
+}
+
+// WaitForStartup wakes app's group, or joins the queue of an in-flight wake,
+// and returns once it is ready. Connections past the queue depth are
+// refused straight away rather than piling up.
+func (s *Server) WaitForStartup(ctx context.Context, app Service) (err error) {
+	var st *startup
+	err = func() error {
+		s.ServerLock.Lock()
+		defer s.ServerLock.Unlock()
+		var ok bool
+		st, ok = s.ServiceStartups[app.Name]
+		if !ok {
+			if err := s.CheckBreaker(app); err != nil {
+				return err
+			}
+			st = &startup{done: make(chan struct{})}
+			s.ServiceStartups[app.Name] = st
+			s.Audit(ctx, AuditWake, app, nil)
+			go func() {
+				// later connections queue behind this one's wake, so it
+				// carries on if this one hangs up, until shutdown
+				ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+				defer cancel()
+				defer context.AfterFunc(s.ctx, cancel)()
+				ctx, span := tracer.Start(ctx, "launch", trace.WithAttributes(attribute.String("service", app.Name)))
+				err := s.LaunchGroup(ctx, app)
+				endSpan(span, err)
+				s.ReportLaunchResult(ctx, app, err)
+				s.ServerLock.Lock()
+				defer s.ServerLock.Unlock()
+				st.err = err
+				s.RecordLaunch(app, err)
+				delete(s.ServiceStartups, app.Name)
+				close(st.done)
+			}()
+		} else if app.StartupQueueDepth > 0 && st.waiting >= app.StartupQueueDepth {
+			return fmt.Errorf("startup queue is full")
+		}
+		st.waiting++
+		return nil
+	}()
+	if err != nil {
+		return
+	}
+	defer func() {
+		s.ServerLock.Lock()
+		defer s.ServerLock.Unlock()
+		st.waiting--
+	}()
+
+	var timeout <-chan time.Time
+	if app.StartupTimeout > 0 {
+		timer := time.NewTimer(time.Duration(app.StartupTimeout) * time.Second)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-st.done:
+		return st.err
+	case <-timeout:
+		return fmt.Errorf("timed out waiting for startup")
+	}
+}
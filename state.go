@@ -0,0 +1,330 @@
+package fishingboat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	bolt "go.etcd.io/bbolt"
+)
+
+var stateBucket = []byte("services")
+
+type StateConfig struct {
+	// bolt database file
+	Path string `json:"path"`
+	// seconds between snapshots, defaults to 5
+	Interval int `json:"interval,omitempty"`
+}
+
+// ServiceState is what the proxy knows about a service's container that
+// would otherwise be lost on restart.
+type ServiceState struct {
+	Ports     map[int]int `json:"ports,omitempty"`
+	Gpus      []string    `json:"gpus,omitempty"`
+	Cpus      []int       `json:"cpus,omitempty"`
+	StartTime time.Time   `json:"startTime"`
+	LastUsed  time.Time   `json:"lastUsed"`
+	KillTime  time.Time   `json:"killTime"`
+	WarmUntil time.Time   `json:"warmUntil"`
+}
+
+// StateStore keeps service state in a bolt database. The file is only held
+// open while reading or writing so other commands can use it too. A nil
+// *StateStore stores nothing.
+type StateStore struct {
+	config StateConfig
+	saved  map[string][]byte
+}
+
+func NewStateStore(config StateConfig) *StateStore {
+	return &StateStore{config: config, saved: make(map[string][]byte)}
+}
+
+func (st *StateStore) open() (*bolt.DB, error) {
+	return bolt.Open(st.config.Path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+}
+
+func (st *StateStore) Load() (states map[string]ServiceState, err error) {
+	states = make(map[string]ServiceState)
+	if st == nil {
+		return
+	}
+	db, err := st.open()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	err = db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(stateBucket)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var state ServiceState
+			if err := json.Unmarshal(v, &state); err != nil {
+				return err
+			}
+			states[string(k)] = state
+			st.saved[string(k)] = append([]byte(nil), v...)
+			return nil
+		})
+	})
+	return
+}
+
+// Save replaces the stored state, only touching the file if something changed.
+func (st *StateStore) Save(states map[string]ServiceState) (err error) {
+	if st == nil {
+		return
+	}
+	encoded := make(map[string][]byte)
+	changed := len(states) != len(st.saved)
+	for name, state := range states {
+		encoded[name], err = json.Marshal(state)
+		if err != nil {
+			return
+		}
+		changed = changed || !bytes.Equal(encoded[name], st.saved[name])
+	}
+	if !changed {
+		return
+	}
+	db, err := st.open()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	err = db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(stateBucket) != nil {
+			if err := tx.DeleteBucket(stateBucket); err != nil {
+				return err
+			}
+		}
+		bucket, err := tx.CreateBucket(stateBucket)
+		if err != nil {
+			return err
+		}
+		for name, buf := range encoded {
+			if err := bucket.Put([]byte(name), buf); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		st.saved = encoded
+	}
+	return
+}
+
+// SnapshotState collects the state of every service with a container.
+func (s *Server) SnapshotState() map[string]ServiceState {
+	states := make(map[string]ServiceState)
+	func() {
+		s.ServerLock.RLock()
+		defer s.ServerLock.RUnlock()
+		for name, startTime := range s.ServiceStartTime {
+			killTime, _ := s.KillTime(name)
+			state := ServiceState{
+				StartTime: startTime,
+				LastUsed:  s.LastUsed(name),
+				KillTime:  killTime,
+				WarmUntil: s.ServiceWarmUntil[name],
+			}
+			if ports, ok := s.ServiceProxyHostPortMap[name]; ok {
+				state.Ports = make(map[int]int)
+				for containerPort, hostPort := range ports {
+					state.Ports[containerPort] = hostPort
+				}
+			}
+			states[name] = state
+		}
+	}()
+	s.TrackedResourcesLock.RLock()
+	defer s.TrackedResourcesLock.RUnlock()
+	for name, state := range states {
+		state.Gpus = s.GpuAllocations[name]
+		state.Cpus = s.CpuAllocations[name]
+		states[name] = state
+	}
+	return states
+}
+
+// RestoreState puts back a service's saved timers, and its port map and
+// allocations in case Docker doesn't report them.
+func (s *Server) RestoreState(name string, state ServiceState) {
+	func() {
+		s.ServerLock.Lock()
+		defer s.ServerLock.Unlock()
+		if len(state.Ports) > 0 {
+			s.ServiceProxyHostPortMap[name] = state.Ports
+		}
+		if !state.LastUsed.IsZero() {
+			s.SetLastUsed(name, state.LastUsed)
+		}
+		if !state.KillTime.IsZero() {
+			s.SetKillTime(name, state.KillTime)
+		}
+		if !state.WarmUntil.IsZero() {
+			s.ServiceWarmUntil[name] = state.WarmUntil
+		}
+	}()
+	s.TrackedResourcesLock.Lock()
+	defer s.TrackedResourcesLock.Unlock()
+	if len(state.Gpus) > 0 {
+		s.GpuAllocations[name] = state.Gpus
+	}
+	if len(state.Cpus) > 0 {
+		s.CpuAllocations[name] = state.Cpus
+	}
+}
+
+func (s *Server) PersistState(ctx context.Context) {
+	interval := 5 * time.Second
+	if s.Config.State.Interval > 0 {
+		interval = time.Duration(s.Config.State.Interval) * time.Second
+	}
+	for sleep(ctx, interval) {
+		if err := s.State.Save(s.SnapshotState()); err != nil {
+			s.Logger.Error("Error saving state", "path", s.Config.State.Path, "err", err)
+		}
+	}
+	// one last snapshot on the way out
+	if err := s.State.Save(s.SnapshotState()); err != nil {
+		s.Logger.Error("Error saving state", "path", s.Config.State.Path, "err", err)
+	}
+}
+
+// ExportedService is a service's saved state along with where its container
+// was placed, as written by `fishingboat state export`.
+type ExportedService struct {
+	ServiceState
+	Container string `json:"container,omitempty"`
+	Node      string `json:"node,omitempty"`
+	Image     string `json:"image,omitempty"`
+	Status    string `json:"status,omitempty"`
+}
+
+type StateExport struct {
+	Exported time.Time                  `json:"exported"`
+	Services map[string]ExportedService `json:"services"`
+}
+
+// ExportState writes the saved state and every managed container's placement
+// and port map as JSON to path, or stdout if path is empty or "-".
+func ExportState(config *ServicesConfig, path string) (err error) {
+	var store *StateStore
+	if config.State != nil {
+		store = NewStateStore(*config.State)
+	}
+	states, err := store.Load()
+	if err != nil {
+		return
+	}
+	export := StateExport{Exported: time.Now(), Services: make(map[string]ExportedService)}
+	for name, state := range states {
+		export.Services[name] = ExportedService{ServiceState: state}
+	}
+
+	s := newServer(*config)
+	defer s.CloseDocker()
+	err = s.ValidateNaming()
+	if err != nil {
+		return
+	}
+	for _, app := range s.Instances() {
+		var cont *types.Container
+		var cli *client.Client
+		cont, cli, err = s.exportContainer(app)
+		if err != nil {
+			return
+		}
+		if cont == nil {
+			continue
+		}
+		service := export.Services[app.Name]
+		service.Container = cont.ID
+		service.Image = cont.Image
+		service.Status = cont.State
+		if len(service.Ports) == 0 {
+			err = s.LoadPortMappings(cli, app, cont.ID)
+			if err != nil {
+				return
+			}
+			service.Ports = s.ServiceProxyHostPortMap[app.Name]
+		}
+		if node := s.NodeOf(app.Name); node != nil {
+			service.Node = node.Name
+		}
+		export.Services[app.Name] = service
+	}
+
+	out := os.Stdout
+	if path != "" && path != "-" {
+		out, err = os.Create(path)
+		if err != nil {
+			return
+		}
+		defer out.Close()
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(export)
+}
+
+// exportContainer finds app's container on whichever Docker host has it,
+// along with a client for that host.
+func (s *Server) exportContainer(app Service) (cont *types.Container, cli *client.Client, err error) {
+	err = s.LocateService(app)
+	if err != nil {
+		return
+	}
+	cli, err = s.Docker(app.Name)
+	if err != nil {
+		return
+	}
+	cont, err = s.FindContainer(cli, app.Name)
+	return
+}
+
+// ImportState merges an export from path, or stdin if path is empty or "-",
+// into the state store. fishingboat should be stopped first, or it will
+// overwrite the import with its own snapshot.
+func ImportState(config *ServicesConfig, path string) (err error) {
+	if config.State == nil || config.State.Path == "" {
+		return fmt.Errorf("state is not enabled in the config")
+	}
+	in := os.Stdin
+	if path != "" && path != "-" {
+		in, err = os.Open(path)
+		if err != nil {
+			return
+		}
+		defer in.Close()
+	}
+	var export StateExport
+	if err = json.NewDecoder(in).Decode(&export); err != nil {
+		return
+	}
+
+	store := NewStateStore(*config.State)
+	states, err := store.Load()
+	if err != nil {
+		return
+	}
+	s := newServer(*config)
+	for name, service := range export.Services {
+		if s.FindService(name) == nil {
+			s.Logger.Warn("Skipping state for unknown service", "service", name)
+			continue
+		}
+		states[name] = service.ServiceState
+	}
+	return store.Save(states)
+}
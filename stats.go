@@ -0,0 +1,126 @@
+package fishingboat
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// CollectStats periodically samples actual CPU and memory usage of running
+// managed containers, so services using more than they requested (or with no
+// request at all) still count against the allocation limits.
+func (s *Server) CollectStats(ctx context.Context) {
+	if s.Config.StatsInterval <= 0 {
+		return
+	}
+	for sleep(ctx, time.Duration(s.Config.StatsInterval)*time.Second) {
+		for _, app := range s.Instances() {
+			running := false
+			func() {
+				s.ServerLock.RLock()
+				defer s.ServerLock.RUnlock()
+				_, running = s.ServiceStartTime[app.Name]
+			}()
+			if !running {
+				func() {
+					s.TrackedResourcesLock.Lock()
+					defer s.TrackedResourcesLock.Unlock()
+					delete(s.MeasuredResources, app.Name)
+				}()
+				continue
+			}
+			usage, err := s.SampleUsage(app)
+			if err != nil {
+				s.Logger.Error("Error collecting stats", "service", app.Name, "err", err)
+				continue
+			}
+			func() {
+				s.TrackedResourcesLock.Lock()
+				defer s.TrackedResourcesLock.Unlock()
+				// video memory is filled in by SampleGpu
+				usage.GpuMemoryMi = s.MeasuredResources[app.Name].GpuMemoryMi
+				s.MeasuredResources[app.Name] = usage
+			}()
+			if usage.MilliCPU > app.IdleMcpu {
+				func() {
+					s.ServerLock.Lock()
+					defer s.ServerLock.Unlock()
+					s.ServiceLastBusy[app.Name] = s.Clock.Now()
+				}()
+			}
+		}
+		if s.Config.GpuStats {
+			err := s.SampleGpu()
+			if err != nil {
+				s.Logger.Error("Error collecting GPU stats", "err", err)
+			}
+		}
+	}
+}
+
+func (s *Server) SampleUsage(app Service) (usage Resources, err error) {
+	cli, err := s.Docker(app.Name)
+	if err != nil {
+		return
+	}
+
+	var cont *types.Container
+	cont, err = s.FindContainer(cli, app.Name)
+	if err != nil || cont == nil {
+		return
+	}
+
+	// a non-streamed sample includes the previous cpu reading to diff against
+	ctx, cancel := s.dockerContext(s.ctx)
+	defer cancel()
+	var resp types.ContainerStats
+	resp, err = cli.ContainerStats(ctx, cont.ID, false)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	var stats types.StatsJSON
+	err = json.NewDecoder(resp.Body).Decode(&stats)
+	if err != nil {
+		return
+	}
+
+	cpuDelta := float64(stats.CPUStats.CPUUsage.TotalUsage) - float64(stats.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(stats.CPUStats.SystemUsage) - float64(stats.PreCPUStats.SystemUsage)
+	if cpuDelta > 0 && systemDelta > 0 {
+		usage.MilliCPU = int(cpuDelta / systemDelta * float64(stats.CPUStats.OnlineCPUs) * 1000)
+	}
+
+	memory := stats.MemoryStats.Usage
+	// page cache is reclaimable, don't count it (cgroup v2 / v1)
+	if cache, ok := stats.MemoryStats.Stats["inactive_file"]; ok && cache < memory {
+		memory -= cache
+	} else if cache, ok := stats.MemoryStats.Stats["total_inactive_file"]; ok && cache < memory {
+		memory -= cache
+	}
+	usage.MemoryMi = int(memory / 1024 / 1024)
+	return
+}
+
+// UsageOverRequests sums how far measured usage exceeds requests across
+// services. TrackedResourcesLock must be held.
+func (s *Server) UsageOverRequests() (over Resources) {
+	for name, usage := range s.MeasuredResources {
+		request := Resources{}
+		if app := s.FindService(name); app != nil && app.ResourceRequest != nil {
+			request = *app.ResourceRequest
+		}
+		if usage.MilliCPU > request.MilliCPU {
+			over.MilliCPU += usage.MilliCPU - request.MilliCPU
+		}
+		if usage.MemoryMi > request.MemoryMi {
+			over.MemoryMi += usage.MemoryMi - request.MemoryMi
+		}
+		if usage.GpuMemoryMi > request.GpuMemoryMi {
+			over.GpuMemoryMi += usage.GpuMemoryMi - request.GpuMemoryMi
+		}
+	}
+	return
+}
@@ -0,0 +1,121 @@
+package fishingboat
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+type StatsDConfig struct {
+	// host:port of the statsd agent
+	Address string `json:"address"`
+	Prefix  string `json:"prefix,omitempty"`
+	// DogStatsD tags like "env:home", sent with every metric
+	Tags      []string `json:"tags,omitempty"`
+	DogStatsD bool     `json:"dogStatsD,omitempty"`
+	// seconds between gauge pushes, defaults to 10
+	Interval int `json:"interval,omitempty"`
+}
+
+// StatsD pushes metrics over UDP. A nil *StatsD drops everything.
+type StatsD struct {
+	config StatsDConfig
+	conn   net.Conn
+}
+
+func NewStatsD(config StatsDConfig) (*StatsD, error) {
+	conn, err := net.Dial("udp", config.Address)
+	if err != nil {
+		return nil, err
+	}
+	return &StatsD{config: config, conn: conn}, nil
+}
+
+func (d *StatsD) send(name string, value string, kind string, tags ...string) {
+	if d == nil {
+		return
+	}
+	if !d.config.DogStatsD {
+		// plain statsd has no tags, so per-metric ones go in the name
+		for _, tag := range tags {
+			_, v, _ := strings.Cut(tag, ":")
+			name += "." + strings.ReplaceAll(v, ".", "_")
+		}
+	}
+	line := d.config.Prefix + name + ":" + value + "|" + kind
+	if d.config.DogStatsD {
+		tags = append(tags, d.config.Tags...)
+		if len(tags) > 0 {
+			line += "|#" + strings.Join(tags, ",")
+		}
+	}
+	// best effort, a missing agent shouldn't hold anything up
+	d.conn.Write([]byte(line))
+}
+
+func (d *StatsD) Gauge(name string, value float64, tags ...string) {
+	d.send(name, fmt.Sprint(value), "g", tags...)
+}
+
+func (d *StatsD) Count(name string, value int64, tags ...string) {
+	d.send(name, fmt.Sprint(value), "c", tags...)
+}
+
+func (d *StatsD) Timing(name string, ms int64, tags ...string) {
+	d.send(name, fmt.Sprint(ms), "ms", tags...)
+}
+
+// PushGauges periodically reports every service's state.
+func (s *Server) PushGauges(ctx context.Context) {
+	interval := 10 * time.Second
+	if s.StatsD.config.Interval > 0 {
+		interval = time.Duration(s.StatsD.config.Interval) * time.Second
+	}
+	s.Logger.Info("Pushing metrics to statsd", "address", s.StatsD.config.Address)
+	for sleep(ctx, interval) {
+		for _, app := range s.Instances() {
+			tag := "service:" + app.Name
+			connections := s.ConnCount(app.Name)
+			var running bool
+			func() {
+				s.ServerLock.RLock()
+				defer s.ServerLock.RUnlock()
+				_, running = s.ServiceStartTime[app.Name]
+			}()
+			s.StatsD.Gauge("connections", float64(connections), tag)
+			up := 0.0
+			if running {
+				up = 1
+			}
+			s.StatsD.Gauge("running", up, tag)
+
+			var usage Resources
+			var measured bool
+			func() {
+				s.TrackedResourcesLock.RLock()
+				defer s.TrackedResourcesLock.RUnlock()
+				usage, measured = s.MeasuredResources[app.Name]
+			}()
+			if measured {
+				s.StatsD.Gauge("usage.mcpu", float64(usage.MilliCPU), tag)
+				s.StatsD.Gauge("usage.memory_mi", float64(usage.MemoryMi), tag)
+				s.StatsD.Gauge("usage.gpu_memory_mi", float64(usage.GpuMemoryMi), tag)
+			}
+		}
+		var tracked Resources
+		func() {
+			s.TrackedResourcesLock.RLock()
+			defer s.TrackedResourcesLock.RUnlock()
+			tracked = s.TrackedResources
+		}()
+		s.StatsD.Gauge("reserved.mcpu", float64(tracked.MilliCPU))
+		s.StatsD.Gauge("reserved.memory_mi", float64(tracked.MemoryMi))
+		s.StatsD.Gauge("reserved.gpu_memory_mi", float64(tracked.GpuMemoryMi))
+		if s.ConnSlots != nil {
+			s.StatsD.Gauge("connections.proxied", float64(len(s.ConnSlots)))
+			s.StatsD.Gauge("connections.max", float64(cap(s.ConnSlots)))
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package fishingboat
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// URL is where the running daemon's admin API can be reached from this host.
+func (c *AdminConfig) URL() string {
+	scheme := "http://"
+	if c.TLS != nil {
+		scheme = "https://"
+	}
+	host, port, err := net.SplitHostPort(c.Bind)
+	if err != nil {
+		return scheme + c.Bind
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	return scheme + net.JoinHostPort(host, port)
+}
+
+// Client talks to the local admin API, trusting its certificate even if
+// it is self-signed, along with a token to send if there is one.
+func (c *AdminConfig) Client() (client *http.Client, token string, err error) {
+	client = &http.Client{Timeout: 10 * time.Second}
+	token = c.Token
+	for _, t := range c.Tokens {
+		if token == "" {
+			token = t.Token
+		}
+	}
+	if c.TLS != nil {
+		var roots *x509.CertPool
+		roots, err = x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		var pem []byte
+		pem, err = os.ReadFile(c.TLS.Cert)
+		if err != nil {
+			return
+		}
+		roots.AppendCertsFromPEM(pem)
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}
+	}
+	return
+}
+
+// adminRequest calls the running daemon's admin API. Requests that may run
+// long, like following logs, are sent without a timeout.
+func adminRequest(config *ServicesConfig, method string, path string, long bool) (resp *http.Response, err error) {
+	if config.Admin == nil || config.Admin.Bind == "" {
+		return nil, fmt.Errorf("the admin API is not enabled in the config")
+	}
+	req, err := http.NewRequest(method, config.Admin.URL()+path, nil)
+	if err != nil {
+		return
+	}
+	client, token, err := config.Admin.Client()
+	if err != nil {
+		return
+	}
+	if long {
+		client.Timeout = 0
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err = client.Do(req)
+	if err != nil {
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("admin API returned status %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return
+}
+
+// Status prints the daemon's view of every service, or just the named one.
+func Status(config *ServicesConfig, name string) (err error) {
+	path := "/services"
+	if name != "" {
+		path += "/" + url.PathEscape(name)
+	}
+	resp, err := adminRequest(config, http.MethodGet, path, false)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	var statuses []ServiceStatus
+	if name != "" {
+		var status ServiceStatus
+		err = json.NewDecoder(resp.Body).Decode(&status)
+		statuses = append(statuses, status)
+	} else {
+		err = json.NewDecoder(resp.Body).Decode(&statuses)
+	}
+	if err != nil {
+		return
+	}
+	return printStatuses(statuses)
+}
+
+// Wake starts a service through the running daemon, with the usual cooldown
+// if nothing connects to it.
+func Wake(config *ServicesConfig, name string) (err error) {
+	return serviceAction(config, name, "start", "")
+}
+
+// Sleep scales a service down through the running daemon, waiting for its
+// connections to finish for drain, or its drainTimeout if drain is negative.
+func Sleep(config *ServicesConfig, name string, drain time.Duration) (err error) {
+	query := ""
+	if drain >= 0 {
+		query = "?drain=" + strconv.Itoa(int(drain/time.Second))
+	}
+	return serviceAction(config, name, "sleep", query)
+}
+
+// Disable closes a service's listeners in the running daemon until it is
+// enabled again.
+func Disable(config *ServicesConfig, name string) (err error) {
+	return serviceAction(config, name, "disable", "")
+}
+
+// Enable opens a disabled service's listeners again.
+func Enable(config *ServicesConfig, name string) (err error) {
+	return serviceAction(config, name, "enable", "")
+}
+
+func serviceAction(config *ServicesConfig, name string, action string, query string) (err error) {
+	resp, err := adminRequest(config, http.MethodPost, "/services/"+url.PathEscape(name)+"/"+action+query, true)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	var status ServiceStatus
+	err = json.NewDecoder(resp.Body).Decode(&status)
+	if err != nil {
+		return
+	}
+	return printStatuses([]ServiceStatus{status})
+}
+
+// Logs copies a service's container output from the running daemon to out,
+// the last tail lines (all if negative) and then new ones if follow is set.
+func Logs(config *ServicesConfig, name string, tail int, follow bool, out io.Writer) (err error) {
+	query := url.Values{}
+	if tail >= 0 {
+		query.Set("tail", strconv.Itoa(tail))
+	}
+	if follow {
+		query.Set("follow", "true")
+	}
+	resp, err := adminRequest(config, http.MethodGet, "/services/"+url.PathEscape(name)+"/logs?"+query.Encode(), true)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(out, resp.Body)
+	return
+}
+
+func printStatuses(statuses []ServiceStatus) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVICE\tSTATE\tCONNECTIONS\tSCALE-DOWN\tLAST-COLD-START\tMCPU\tMEMORY-MI\tGPU-MEMORY-MI")
+	for _, status := range statuses {
+		state := status.State
+		if status.Disabled {
+			state += " (disabled)"
+		}
+		scaleDown := "-"
+		if status.Cooldown != nil {
+			scaleDown = (time.Duration(*status.Cooldown) * time.Second).String()
+		}
+		lastColdStart := "-"
+		if n := len(status.ColdStarts); n > 0 {
+			cs := status.ColdStarts[n-1]
+			lastColdStart = fmt.Sprintf("%s (pull %s, ready %s)", msString(cs.TotalMs), msString(cs.PullMs), msString(cs.ReadinessMs))
+		}
+		var requested Resources
+		if status.Requested != nil {
+			requested = *status.Requested
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%d\t%d\t%d\n", status.Name, state, status.Connections, scaleDown,
+			lastColdStart, requested.MilliCPU, requested.MemoryMi, requested.GpuMemoryMi)
+	}
+	return w.Flush()
+}
+
+func msString(ms int64) string {
+	return (time.Duration(ms) * time.Millisecond).String()
+}
@@ -0,0 +1,180 @@
+package fishingboat
+
+import (
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+const (
+	UpdateRecreate  = "recreate"
+	UpdateBlueGreen = "bluegreen"
+)
+
+// suffix of the service name a blue/green update starts its new container under
+const successorSuffix = "-next"
+
+func SuccessorService(app Service) Service {
+	successor := app
+	successor.Name = app.Name + successorSuffix
+	successor.Group = ""
+	successor.Schedules = nil
+	return successor
+}
+
+// findSuccessor resolves the name of an in-flight blue/green container.
+func (s *Server) findSuccessor(name string) *Service {
+	if !strings.HasSuffix(name, successorSuffix) {
+		return nil
+	}
+	app := s.FindService(strings.TrimSuffix(name, successorSuffix))
+	if app == nil {
+		return nil
+	}
+	successor := SuccessorService(*app)
+	return &successor
+}
+
+// Successor returns the service new connections for app should go to while a
+// blue/green update is shifting traffic.
+func (s *Server) Successor(app Service) Service {
+	s.ServerLock.RLock()
+	defer s.ServerLock.RUnlock()
+	if name, ok := s.ServiceSuccessors[app.Name]; ok {
+		if successor := s.findSuccessor(name); successor != nil {
+			return *successor
+		}
+	}
+	return app
+}
+
+// BlueGreenUpdate brings up app's updated image alongside the old container,
+// shifts new connections to it once ready, and retires the old container
+// after its connections drain.
+func (s *Server) BlueGreenUpdate(app Service) (err error) {
+	successor := SuccessorService(app)
+	s.Logger.Info("Starting updated container alongside the old one", "service", app.Name)
+	defer func() {
+		if err != nil {
+			s.ServerLock.Lock()
+			defer s.ServerLock.Unlock()
+			delete(s.ServiceSuccessors, app.Name)
+		}
+	}()
+	err = s.LaunchContainer(s.ctx, successor)
+	if err != nil {
+		return
+	}
+	func() {
+		s.ServerLock.Lock()
+		defer s.ServerLock.Unlock()
+		s.ServiceSuccessors[app.Name] = successor.Name
+	}()
+	s.Logger.Info("Shifted new connections to the updated container", "service", app.Name)
+
+	// let the old container's connections finish
+	var deadline time.Time
+	if app.DrainTimeout > 0 {
+		deadline = time.Now().Add(time.Duration(app.DrainTimeout) * time.Second)
+	}
+	for deadline.IsZero() || time.Now().Before(deadline) {
+		if s.ConnCount(app.Name) == 0 {
+			break
+		}
+		time.Sleep(1 * time.Second)
+	}
+
+	cli, err := s.Docker(successor.Name)
+	if err != nil {
+		return
+	}
+
+	s.ContainerAPILock.Lock(app.Name)
+	defer s.ContainerAPILock.Unlock(app.Name)
+	s.ContainerAPILock.Lock(successor.Name)
+	defer s.ContainerAPILock.Unlock(successor.Name)
+
+	var cont *types.Container
+	cont, err = s.FindContainer(cli, app.Name)
+	if err != nil {
+		return
+	}
+	if cont != nil {
+		err = s.RemoveContainer(cli, app, cont)
+		if err != nil {
+			return
+		}
+		s.Logger.Info("Removed old container", "service", app.Name, "container", cont.ID)
+	}
+	cont, err = s.FindContainer(cli, successor.Name)
+	if err != nil {
+		return
+	}
+	if cont == nil {
+		// it went idle and was removed while the old one drained
+		func() {
+			s.ServerLock.Lock()
+			defer s.ServerLock.Unlock()
+			delete(s.ServiceSuccessors, app.Name)
+		}()
+		return
+	}
+	ctx, cancel := s.dockerContext(s.ctx)
+	defer cancel()
+	err = cli.ContainerRename(ctx, cont.ID, s.ContainerName(app.Name))
+	if err != nil {
+		return
+	}
+	s.Promote(successor, app)
+	func() {
+		s.ServerLock.Lock()
+		defer s.ServerLock.Unlock()
+		delete(s.ServiceSuccessors, app.Name)
+	}()
+	s.Logger.Info("Updated container took over", "service", app.Name, "container", cont.ID)
+	return
+}
+
+// Promote moves everything tracked for a container over to app after it
+// was renamed to app's container.
+func (s *Server) Promote(successor Service, app Service) {
+	func() {
+		s.TrackedResourcesLock.Lock()
+		defer s.TrackedResourcesLock.Unlock()
+		if gpus, ok := s.GpuAllocations[successor.Name]; ok {
+			s.GpuAllocations[app.Name] = gpus
+			delete(s.GpuAllocations, successor.Name)
+		}
+		if cpus, ok := s.CpuAllocations[successor.Name]; ok {
+			s.CpuAllocations[app.Name] = cpus
+			delete(s.CpuAllocations, successor.Name)
+		}
+		// it shared app's node
+		delete(s.ServiceNodes, successor.Name)
+	}()
+
+	s.ServerLock.Lock()
+	defer s.ServerLock.Unlock()
+	if s.DependencyHolds[successor.Name] {
+		s.DependencyHolds[app.Name] = true
+		delete(s.DependencyHolds, successor.Name)
+	}
+	if ports, ok := s.ServiceProxyHostPortMap[successor.Name]; ok {
+		s.ServiceProxyHostPortMap[app.Name] = ports
+		s.ServicePortsContainer[app.Name] = s.ServicePortsContainer[successor.Name]
+		delete(s.ServiceProxyHostPortMap, successor.Name)
+		delete(s.ServicePortsContainer, successor.Name)
+	}
+	if started, ok := s.ServiceStartTime[successor.Name]; ok {
+		s.ServiceStartTime[app.Name] = started
+		delete(s.ServiceStartTime, successor.Name)
+	}
+	// connections still open on the old container are gone with it
+	s.promoteConns(successor.Name, app.Name)
+	if s.ConnCount(app.Name) == 0 {
+		s.ScheduleGroupKill(app)
+	} else {
+		s.ClearKillTime(app.Name)
+	}
+}